@@ -5,4 +5,6 @@ go 1.13
 require (
 	github.com/gobuffalo/packr/v2 v2.7.1
 	github.com/stretchr/testify v1.4.0
+	golang.org/x/text v0.3.8
+	gopkg.in/yaml.v2 v2.2.2
 )
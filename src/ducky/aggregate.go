@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paidright/datalab/util"
+)
+
+// aggregateRules are the ways the cells of rows taped together can be combined.
+// distinct-concat isn't here as it needs every value seen in a run, which the
+// run keeps instead.
+var aggregateRules = map[string]func(into string, cell string) string{
+	"sum":   sumCells,
+	"min":   minCell,
+	"max":   maxCell,
+	"first": func(into string, cell string) string { return into },
+	"last":  func(into string, cell string) string { return cell },
+	"count": countRows,
+}
+
+// aggregate combines a column of rows taped together according to a rule.
+// Columns without one keep the value of the first row.
+type aggregate struct {
+	Column string
+	Rule   string
+	// Separator goes between the values listed by distinct-concat
+	Separator string
+}
+
+func ruleNames() string {
+	names := []string{"distinct-concat"}
+	for name := range aggregateRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// parseAggregates reads a comma separated list of column:rule pairs
+func parseAggregates(input string, separator string, matchOn []matchSet) ([]aggregate, error) {
+	aggregates := []aggregate{}
+	for _, set := range strings.Split(input, ",") {
+		bits := strings.SplitN(set, ":", 2)
+		if len(bits) != 2 {
+			return aggregates, fmt.Errorf("expected column:rule but got %s", set)
+		}
+		if _, ok := aggregateRules[bits[1]]; !ok && bits[1] != "distinct-concat" {
+			return aggregates, fmt.Errorf("unknown rule %s, expected one of %s", bits[1], ruleNames())
+		}
+		for _, match := range matchOn {
+			if match.Left == bits[0] || (match.Interval && match.Right == bits[0]) {
+				return aggregates, fmt.Errorf("column %s is already merged by a match", bits[0])
+			}
+		}
+		aggregates = append(aggregates, aggregate{
+			Column:    bits[0],
+			Rule:      bits[1],
+			Separator: separator,
+		})
+	}
+	return aggregates, nil
+}
+
+// run holds what the aggregates need to remember about the rows taped
+// together so far that doesn't fit in their cells
+type run struct {
+	// distinct lists each different value of the distinct-concat columns in
+	// the order they were seen
+	distinct map[string][]string
+}
+
+// startRun readies a line to have others taped on to it
+func startRun(line util.Line, aggregates []aggregate) *run {
+	r := &run{distinct: map[string][]string{}}
+	for _, agg := range aggregates {
+		switch agg.Rule {
+		case "count":
+			line.Data[agg.Column] = "1"
+		case "distinct-concat":
+			r.see(agg.Column, line.Data[agg.Column])
+		}
+	}
+	return r
+}
+
+// aggregateLines folds the cells of line into those of into
+func aggregateLines(into util.Line, line util.Line, aggregates []aggregate, r *run) {
+	for _, agg := range aggregates {
+		if agg.Rule == "distinct-concat" {
+			r.see(agg.Column, line.Data[agg.Column])
+			continue
+		}
+		into.Data[agg.Column] = aggregateRules[agg.Rule](into.Data[agg.Column], line.Data[agg.Column])
+	}
+}
+
+// endRun writes out what the run remembered once no more lines will be taped
+// on to line
+func endRun(line util.Line, aggregates []aggregate, r *run) {
+	for _, agg := range aggregates {
+		if agg.Rule == "distinct-concat" && len(r.distinct[agg.Column]) > 0 {
+			line.Data[agg.Column] = strings.Join(r.distinct[agg.Column], agg.Separator)
+		}
+	}
+}
+
+// see notes a value of a distinct-concat column, ignoring blanks
+func (r *run) see(col string, cell string) {
+	if strings.TrimSpace(cell) == "" || util.Contains(cell, r.distinct[col]) {
+		return
+	}
+	r.distinct[col] = append(r.distinct[col], cell)
+}
+
+// sumCells adds numbers exactly, treating blank cells as zero
+func sumCells(into string, cell string) string {
+	if strings.TrimSpace(cell) == "" {
+		return into
+	}
+	if strings.TrimSpace(into) == "" {
+		return cell
+	}
+	a, aok := util.ParseDecimal(into)
+	b, bok := util.ParseDecimal(cell)
+	if !aok || !bok {
+		logger.Error(fmt.Errorf("cannot sum %q and %q, keeping %q", into, cell, into))
+		return into
+	}
+	return util.FormatDecimal(a.Add(a, b))
+}
+
+// compareCells compares cells as numbers if they both are, and as strings if
+// not, so ISO 8601 dates compare correctly too
+func compareCells(a string, b string) int {
+	x, xok := util.ParseDecimal(a)
+	y, yok := util.ParseDecimal(b)
+	if xok && yok {
+		return x.Cmp(y)
+	}
+	return strings.Compare(a, b)
+}
+
+// minCell keeps the smaller cell, ignoring blanks
+func minCell(into string, cell string) string {
+	if strings.TrimSpace(cell) == "" {
+		return into
+	}
+	if strings.TrimSpace(into) == "" || compareCells(cell, into) < 0 {
+		return cell
+	}
+	return into
+}
+
+// maxCell keeps the larger cell, ignoring blanks
+func maxCell(into string, cell string) string {
+	if strings.TrimSpace(cell) == "" {
+		return into
+	}
+	if strings.TrimSpace(into) == "" || compareCells(cell, into) > 0 {
+		return cell
+	}
+	return into
+}
+
+// countRows counts the rows taped together. startRun counts the first.
+func countRows(into string, cell string) string {
+	n, _ := strconv.Atoi(into)
+	return strconv.Itoa(n + 1)
+}
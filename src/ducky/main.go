@@ -3,9 +3,11 @@ package main
 import (
 	"encoding/csv"
 	"flag"
+	"fmt"
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/paidright/datalab/util"
 )
@@ -18,6 +20,11 @@ var literalLeftMatchInput = flag.String("match-literal-left", "", "A comma separ
 var literalRightMatchInput = flag.String("match-literal-right", "", "A comma separated list values to match the right branch on. eg: paycode:extra_hours")
 var inverseLiteralLeftMatchInput = flag.String("inverse-match-literal-left", "", "A comma separated list values to inverse match the left branch on. eg: paycode:salary")
 var inverseLiteralRightMatchInput = flag.String("inverse-match-literal-right", "", "A comma separated list values to inverse match the right branch on. eg: paycode:extra_hours")
+var intervalMatchInput = flag.String("match-interval", "", "A start and end column to read as datetimes. Rows are merged when their intervals overlap or are no more than --tolerance apart. eg: start:end")
+var layout = flag.String("layout", util.ISOFormat, "Layout of the --match-interval columns eg: 'YYYY-MM-DD hh:mm'. Separate several layouts with |. Defaults to any ISO 8601 date or timestamp")
+var tolerance = flag.Duration("tolerance", 0, "How far apart --match-interval intervals can be and still be merged eg: 1m")
+var aggregateInput = flag.String("aggregate", "", "A comma separated list of rules for combining the other columns of rows that are merged. One of sum, min, max, first, last, count or distinct-concat. eg: hours:sum,paycode:distinct-concat")
+var separator = flag.String("separator", ";", "Separator distinct-concat puts between values")
 var groupKey = flag.String("group-key", "id", "The header with which to group (sorted!) rows")
 
 var logger = util.Logger{}
@@ -113,7 +120,31 @@ func main() {
 		}
 	}
 
-	if err := ducky(os.Stdin, *output, matchOn, *groupKey); err != nil {
+	if *intervalMatchInput != "" {
+		bits := strings.SplitN(*intervalMatchInput, ":", 2)
+		if len(bits) != 2 {
+			logger.Fatal(fmt.Errorf("--match-interval expects a start and an end column eg: start:end"))
+		}
+
+		matchOn = append(matchOn, matchSet{
+			Interval:  true,
+			Left:      bits[0],
+			Right:     bits[1],
+			Layouts:   strings.Split(*layout, "|"),
+			Tolerance: *tolerance,
+		})
+	}
+
+	aggregates := []aggregate{}
+	if *aggregateInput != "" {
+		var err error
+		aggregates, err = parseAggregates(*aggregateInput, *separator, matchOn)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if err := ducky(os.Stdin, *output, matchOn, aggregates, *groupKey); err != nil {
 		logger.Fatal(err)
 	}
 
@@ -122,7 +153,7 @@ func main() {
 	logDone()
 }
 
-func ducky(input io.Reader, output csv.Writer, matchOn []matchSet, groupKey string) error {
+func ducky(input io.Reader, output csv.Writer, matchOn []matchSet, aggregates []aggregate, groupKey string) error {
 	work, errors := util.ReadSourceAsync(input)
 
 	var cachedErr error
@@ -140,6 +171,11 @@ func ducky(input io.Reader, output csv.Writer, matchOn []matchSet, groupKey stri
 
 	for line := range work {
 		if !headersPrinted {
+			for _, agg := range aggregates {
+				if !util.Contains(agg.Column, line.Headers) {
+					return fmt.Errorf("unknown aggregate column %s", agg.Column)
+				}
+			}
 			if err := output.Write(append(line.Headers, "ducky_taped")); err != nil {
 				return err
 			}
@@ -149,7 +185,7 @@ func ducky(input io.Reader, output csv.Writer, matchOn []matchSet, groupKey stri
 		if prevLine.Data[groupKey] == line.Data[groupKey] || len(prevLine.Headers) == 0 {
 			group = append(group, line)
 		} else {
-			result := matchGroup(group, matchOn)
+			result := matchGroup(group, matchOn, aggregates)
 			for _, l := range result {
 				if err := emitLine(l, &output); err != nil {
 					return err
@@ -160,7 +196,7 @@ func ducky(input io.Reader, output csv.Writer, matchOn []matchSet, groupKey stri
 		prevLine = line
 	}
 
-	result := matchGroup(group, matchOn)
+	result := matchGroup(group, matchOn, aggregates)
 	for _, l := range result {
 		if err := emitLine(l, &output); err != nil {
 			return err
@@ -183,6 +219,8 @@ func doLinesMatch(left util.Line, right util.Line, match matchSet) bool {
 		if left.Data[match.Left] == match.Right {
 			matched = true
 		}
+	} else if match.Interval {
+		matched = intervalsTouch(left, right, match)
 	} else {
 		if left.Data[match.Left] == right.Data[match.Right] {
 			matched = true
@@ -213,9 +251,10 @@ func anyLinesMatch(group []util.Line, match matchSet) bool {
 	return anyMatch
 }
 
-func matchGroup(group []util.Line, allMatches []matchSet) []util.Line {
+func matchGroup(group []util.Line, allMatches []matchSet, aggregates []aggregate) []util.Line {
 	if len(group) == 1 {
 		group[0].Data["ducky_taped"] = "false"
+		startRun(group[0], aggregates)
 		return group
 	}
 
@@ -224,6 +263,7 @@ func matchGroup(group []util.Line, allMatches []matchSet) []util.Line {
 			if !anyLinesMatch(group, match) {
 				for _, line := range group {
 					line.Data["ducky_taped"] = "false"
+					startRun(line, aggregates)
 				}
 				return group
 			}
@@ -239,6 +279,7 @@ func matchGroup(group []util.Line, allMatches []matchSet) []util.Line {
 	requiredMatches := len(matchOn)
 
 	prevLine := util.Line{}
+	current := &run{}
 	result := []util.Line{}
 
 	for i, line := range group {
@@ -246,6 +287,7 @@ func matchGroup(group []util.Line, allMatches []matchSet) []util.Line {
 		if i == 0 {
 			prevLine = line
 			prevLine.Data["ducky_taped"] = "false"
+			current = startRun(prevLine, aggregates)
 			continue
 		}
 		for _, match := range matchOn {
@@ -259,18 +301,81 @@ func matchGroup(group []util.Line, allMatches []matchSet) []util.Line {
 		if numMatches == requiredMatches {
 			for _, match := range matchOn {
 				prevLine.Data["ducky_taped"] = "true"
+				if match.Interval {
+					mergeIntervals(prevLine, line, match)
+					continue
+				}
 				prevLine.Data[match.Left] = line.Data[match.Left]
 			}
+			aggregateLines(prevLine, line, aggregates, current)
 		} else {
+			endRun(prevLine, aggregates, current)
 			result = append(result, prevLine)
 			prevLine = line
 			prevLine.Data["ducky_taped"] = "false"
+			current = startRun(prevLine, aggregates)
 		}
 	}
 
+	endRun(prevLine, aggregates, current)
 	return append(result, prevLine)
 }
 
+// interval reads the start and end of a line for an interval match
+func (match matchSet) interval(line util.Line) (time.Time, time.Time, bool) {
+	start, ok := parseTime(match.Layouts, line.Data[match.Left])
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	end, ok := parseTime(match.Layouts, line.Data[match.Right])
+	return start, end, ok
+}
+
+func parseTime(layouts []string, cell string) (time.Time, bool) {
+	if len(layouts) == 0 {
+		layouts = []string{util.ISOFormat}
+	}
+	for _, layout := range layouts {
+		if t, ok := util.ParseDate(layout, strings.TrimSpace(cell)); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// intervalsTouch reports whether the intervals of two lines overlap or are no
+// more than the tolerance apart. Lines that can't be read never touch.
+func intervalsTouch(left util.Line, right util.Line, match matchSet) bool {
+	leftStart, leftEnd, ok := match.interval(left)
+	if !ok {
+		return false
+	}
+	rightStart, rightEnd, ok := match.interval(right)
+	if !ok {
+		return false
+	}
+	return !rightStart.After(leftEnd.Add(match.Tolerance)) && !leftStart.After(rightEnd.Add(match.Tolerance))
+}
+
+// mergeIntervals stretches the interval of into to cover that of line as
+// well, keeping the cells as they were written
+func mergeIntervals(into util.Line, line util.Line, match matchSet) {
+	intoStart, intoEnd, ok := match.interval(into)
+	if !ok {
+		return
+	}
+	start, end, ok := match.interval(line)
+	if !ok {
+		return
+	}
+	if start.Before(intoStart) {
+		into.Data[match.Left] = line.Data[match.Left]
+	}
+	if end.After(intoEnd) {
+		into.Data[match.Right] = line.Data[match.Right]
+	}
+}
+
 func emitLine(line util.Line, output *csv.Writer) error {
 	newLine := []string{}
 	line.Headers = append(line.Headers, "ducky_taped")
@@ -288,6 +393,11 @@ type matchSet struct {
 	LiteralRight bool
 	Inverse      bool
 	MatchAny     bool
+	// Interval matches read Left and Right as the start and end of a time
+	// interval in one of Layouts
+	Interval  bool
+	Layouts   []string
+	Tolerance time.Duration
 }
 
 func logDone() {
@@ -4,6 +4,7 @@ import (
 	"encoding/csv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/paidright/datalab/util"
 	"github.com/stretchr/testify/assert"
@@ -13,6 +14,7 @@ type test struct {
 	name         string
 	input        string
 	matchOn      []matchSet
+	aggregates   []aggregate
 	want         []string
 	demandLength int
 }
@@ -244,6 +246,94 @@ func TestDoLinesMatch(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "interval overlap",
+			left: util.Line{
+				Data: map[string]string{
+					"start": "2020-01-01 09:00:00",
+					"end":   "2020-01-01 11:30:00",
+				},
+			},
+			right: util.Line{
+				Data: map[string]string{
+					"start": "2020-01-01 11:00:00",
+					"end":   "2020-01-01 17:00:00",
+				},
+			},
+			match: matchSet{
+				Interval: true,
+				Left:     "start",
+				Right:    "end",
+			},
+			want: true,
+		},
+		{
+			name: "interval gap within tolerance",
+			left: util.Line{
+				Data: map[string]string{
+					"start": "09:00",
+					"end":   "11:00",
+				},
+			},
+			right: util.Line{
+				Data: map[string]string{
+					"start": "11:01:00",
+					"end":   "17:00:00",
+				},
+			},
+			match: matchSet{
+				Interval:  true,
+				Left:      "start",
+				Right:     "end",
+				Layouts:   []string{"hh:mm", "hh:mm:ss"},
+				Tolerance: time.Minute,
+			},
+			want: true,
+		},
+		{
+			name: "interval gap beyond tolerance",
+			left: util.Line{
+				Data: map[string]string{
+					"start": "09:00",
+					"end":   "11:00",
+				},
+			},
+			right: util.Line{
+				Data: map[string]string{
+					"start": "11:02",
+					"end":   "17:00",
+				},
+			},
+			match: matchSet{
+				Interval:  true,
+				Left:      "start",
+				Right:     "end",
+				Layouts:   []string{"hh:mm"},
+				Tolerance: time.Minute,
+			},
+			want: false,
+		},
+		{
+			name: "interval garbled",
+			left: util.Line{
+				Data: map[string]string{
+					"start": "9am",
+					"end":   "11am",
+				},
+			},
+			right: util.Line{
+				Data: map[string]string{
+					"start": "9am",
+					"end":   "11am",
+				},
+			},
+			match: matchSet{
+				Interval: true,
+				Left:     "start",
+				Right:    "end",
+			},
+			want: false,
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -642,6 +732,59 @@ two,11am,5pm
 				"two,11am,5pm,false",
 			},
 		},
+		{
+			name: "interval",
+			input: `id,start,end
+one,09:00,11:00
+one,11:00:00,14:00:00
+one,13:30,15:00
+one,15:01,17:00
+one,17:30,18:00
+two,09:00,11:00
+two,10:00,10:30
+`,
+			matchOn: []matchSet{
+				matchSet{
+					Interval:  true,
+					Left:      "start",
+					Right:     "end",
+					Layouts:   []string{"hh:mm", "hh:mm:ss"},
+					Tolerance: time.Minute,
+				},
+			},
+			want: []string{
+				"one,09:00,17:00,true",
+				"one,17:30,18:00,false",
+				"two,09:00,11:00,true",
+			},
+			demandLength: 5,
+		},
+		{
+			name: "aggregates",
+			input: `id,start,end,hours,paycode,rate,note
+one,9am,11am,2,ORD,30,
+one,11am,2pm,3,OT,45,late
+one,2pm,5pm,3.5,ORD,30,
+two,9am,11am,2,ORD,30,
+`,
+			matchOn: []matchSet{
+				matchSet{
+					Left:  "end",
+					Right: "start",
+				},
+			},
+			aggregates: []aggregate{
+				{Column: "hours", Rule: "sum"},
+				{Column: "paycode", Rule: "distinct-concat", Separator: ";"},
+				{Column: "rate", Rule: "max"},
+				{Column: "note", Rule: "count"},
+			},
+			want: []string{
+				"one,9am,5pm,8.5,ORD;OT,45,3,true",
+				"two,9am,11am,2,ORD,30,1,false",
+			},
+			demandLength: 4,
+		},
 	}
 
 	for _, tc := range tests {
@@ -650,7 +793,7 @@ two,11am,5pm
 
 			writer := csv.NewWriter(&result)
 
-			assert.Nil(t, ducky(strings.NewReader(tc.input), *writer, tc.matchOn, "id"))
+			assert.Nil(t, ducky(strings.NewReader(tc.input), *writer, tc.matchOn, tc.aggregates, "id"))
 
 			writer.Flush()
 
@@ -663,3 +806,58 @@ two,11am,5pm
 		})
 	}
 }
+
+func TestAggregateRules(t *testing.T) {
+	tests := []struct {
+		rule  string
+		cells []string
+		want  string
+	}{
+		{"sum", []string{"7.5", "", "0.25", "-1"}, "6.75"},
+		{"sum", []string{"", "2"}, "2"},
+		{"min", []string{"10", "9", "", "11"}, "9"},
+		{"min", []string{"2020-02-01", "2020-01-15"}, "2020-01-15"},
+		{"max", []string{"10", "9", "", "11"}, "11"},
+		{"first", []string{"a", "b", "c"}, "a"},
+		{"last", []string{"a", "b", "c"}, "c"},
+		{"count", []string{"a", "b", "c"}, "3"},
+		{"distinct-concat", []string{"ORD", "", "OT", "ORD", "LEAVE"}, "ORD|OT|LEAVE"},
+		{"distinct-concat", []string{"", " "}, ""},
+		// Values holding the separator are still told apart
+		{"distinct-concat", []string{"ORD|OT", "ORD", "OT", "ORD|OT"}, "ORD|OT|ORD|OT"},
+	}
+
+	for _, tc := range tests {
+		aggregates := []aggregate{{Column: "cell", Rule: tc.rule, Separator: "|"}}
+		into := util.Line{Data: map[string]string{"cell": tc.cells[0]}}
+		r := startRun(into, aggregates)
+		for _, cell := range tc.cells[1:] {
+			aggregateLines(into, util.Line{Data: map[string]string{"cell": cell}}, aggregates, r)
+		}
+		endRun(into, aggregates, r)
+		assert.Equal(t, tc.want, into.Data["cell"], tc.rule, tc.cells)
+	}
+}
+
+func TestParseAggregates(t *testing.T) {
+	matchOn := []matchSet{
+		{Left: "end", Right: "start"},
+		{Interval: true, Left: "from", Right: "to"},
+	}
+
+	aggregates, err := parseAggregates("hours:sum,paycode:distinct-concat", "/", matchOn)
+	assert.Nil(t, err)
+	assert.Equal(t, []aggregate{
+		{Column: "hours", Rule: "sum", Separator: "/"},
+		{Column: "paycode", Rule: "distinct-concat", Separator: "/"},
+	}, aggregates)
+
+	for _, bad := range []string{"hours", "hours:average", "end:max", "to:max"} {
+		_, err := parseAggregates(bad, ";", matchOn)
+		assert.NotNil(t, err, bad)
+	}
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+	assert.NotNil(t, ducky(strings.NewReader("id,start,end\none,9am,11am\n"), *writer, []matchSet{}, []aggregate{{Column: "hours", Rule: "sum"}}, "id"))
+}
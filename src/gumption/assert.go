@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/paidright/datalab/util"
+)
+
+// violationsColumn is the column --on-violation flag lists broken rules in
+const violationsColumn = "_violations"
+
+var violationModes = []string{"fail", "flag", "reject"}
+
+var integerPattern = regexp.MustCompile(`^[+-]?\d+$`)
+
+// assertRule is a single check a cell has to pass
+type assertRule struct {
+	// text is the rule as it was written, used to report violations
+	text    string
+	kind    string
+	pattern *regexp.Regexp
+	// min and max bound numbers, either may be nil for an open range
+	min, max *big.Rat
+	inputs   []inputLayout
+	allowed  []string
+	// seen holds the values already met in each column for unique
+	seen map[string]map[string]bool
+}
+
+// parseAssertRule reads a rule such as not-empty, regex:^[A-Z]+$, int:0..100,
+// decimal:..1000, date:DD/MM/YYYY, one-of:A|B|C or unique
+func parseAssertRule(text string) (*assertRule, error) {
+	parts := strings.SplitN(text, ":", 2)
+	r := assertRule{
+		text: text,
+		kind: parts[0],
+	}
+	arg := ""
+	if len(parts) == 2 {
+		arg = parts[1]
+	}
+
+	switch r.kind {
+	case "not-empty", "unique":
+		if arg != "" {
+			return nil, fmt.Errorf("%s doesn't take a value", r.kind)
+		}
+		if r.kind == "unique" {
+			r.seen = map[string]map[string]bool{}
+		}
+
+	case "regex":
+		pattern, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		r.pattern = pattern
+
+	case "int", "decimal":
+		if arg == "" {
+			break
+		}
+		bounds := strings.Split(arg, "..")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("expected a range eg: %s:0..100 but got %s", r.kind, text)
+		}
+		for i, bound := range bounds {
+			if bound == "" {
+				continue
+			}
+			value, ok := util.ParseDecimal(bound)
+			if !ok {
+				return nil, fmt.Errorf("bad bound %s in %s", bound, text)
+			}
+			if i == 0 {
+				r.min = value
+			} else {
+				r.max = value
+			}
+		}
+
+	case "date":
+		if arg == "" {
+			return nil, fmt.Errorf("expected a layout eg: date:YYYY-MM-DD")
+		}
+		for _, format := range strings.Split(arg, "|") {
+			r.inputs = append(r.inputs, inputLayout{
+				format: format,
+				layout: util.DateLayout(format),
+			})
+		}
+
+	case "one-of":
+		if arg == "" {
+			return nil, fmt.Errorf("expected the allowed values eg: one-of:FT|PT|CAS")
+		}
+		r.allowed = strings.Split(arg, "|")
+
+	default:
+		return nil, fmt.Errorf("unknown assertion %s", text)
+	}
+
+	return &r, nil
+}
+
+// check reports whether a cell passes the rule. Blank cells only fail
+// not-empty, so the other rules can be used on optional columns.
+func (r *assertRule) check(col string, cell string) bool {
+	if r.kind == "not-empty" {
+		return !isBlank(cell)
+	}
+	if isBlank(cell) {
+		return true
+	}
+
+	switch r.kind {
+	case "unique":
+		if r.seen[col] == nil {
+			r.seen[col] = map[string]bool{}
+		}
+		if r.seen[col][cell] {
+			return false
+		}
+		r.seen[col][cell] = true
+
+	case "regex":
+		return r.pattern.MatchString(cell)
+
+	case "int", "decimal":
+		cell = strings.TrimSpace(cell)
+		if r.kind == "int" && !integerPattern.MatchString(cell) {
+			return false
+		}
+		value, ok := util.ParseDecimal(cell)
+		if !ok {
+			return false
+		}
+		if r.min != nil && value.Cmp(r.min) < 0 {
+			return false
+		}
+		if r.max != nil && value.Cmp(r.max) > 0 {
+			return false
+		}
+
+	case "date":
+		for _, input := range r.inputs {
+			if _, ok := input.parse(cell); ok {
+				return true
+			}
+		}
+		return false
+
+	case "one-of":
+		return util.Contains(cell, r.allowed)
+	}
+
+	return true
+}
+
+// assertions checks cells against rules and keeps track of the rows that
+// broke them
+type assertions struct {
+	rules []*assertRule
+	// mode is one of fail, flag or reject
+	mode   string
+	broken int
+}
+
+func newAssertions(op *operation) (*assertions, error) {
+	a := assertions{
+		mode: op.option("on-violation", "fail"),
+	}
+	if !util.Contains(a.mode, violationModes) {
+		return nil, fmt.Errorf("on-violation must be one of %s", strings.Join(violationModes, ", "))
+	}
+
+	for _, text := range strings.Split(op.flag.value, "\n") {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		rule, err := parseAssertRule(strings.TrimSpace(text))
+		if err != nil {
+			return nil, err
+		}
+		a.rules = append(a.rules, rule)
+	}
+	if len(a.rules) == 0 {
+		return nil, fmt.Errorf("assert needs at least one rule eg: not-empty")
+	}
+
+	return &a, nil
+}
+
+// violations lists every rule the target columns of a line break, eg:
+// "ID unique"
+func (a *assertions) violations(columns []string, line util.Line) []string {
+	found := []string{}
+	for _, col := range columns {
+		for _, rule := range a.rules {
+			if !rule.check(col, line.Data[col]) {
+				found = append(found, col+" "+rule.text)
+			}
+		}
+	}
+	return found
+}
+
+// finish fails the run if any row broke a rule and the mode is fail
+func (a *assertions) finish() error {
+	if a.mode == "fail" && a.broken > 0 {
+		return fmt.Errorf("%d rows failed assertions", a.broken)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssertRules(t *testing.T) {
+	for _, tc := range []struct {
+		rule   string
+		passes []string
+		fails  []string
+	}{
+		{"not-empty", []string{"a", "0"}, []string{"", "  "}},
+		{"regex:^EMP\\d+$", []string{"EMP1", "EMP123", ""}, []string{"emp1", "EMP"}},
+		{"int", []string{"1", "-20", "+3"}, []string{"1.5", "one"}},
+		{"int:0..100", []string{"0", "100", "42"}, []string{"-1", "101", "50.0"}},
+		{"decimal:..7.6", []string{"7.6", "-3", "0.25"}, []string{"7.61", "abc"}},
+		{"decimal:0.5..", []string{"0.5", "1000"}, []string{"0.49"}},
+		{"date:DD/MM/YYYY|YYYY-MM-DD", []string{"03/02/2020", "2020-02-03"}, []string{"2020-02-30", "3 Feb 2020"}},
+		{"one-of:FT|PT|CAS", []string{"FT", "CAS"}, []string{"ft", "CASUAL"}},
+	} {
+		rule, err := parseAssertRule(tc.rule)
+		assert.Nil(t, err, tc.rule)
+		for _, cell := range tc.passes {
+			assert.True(t, rule.check("COL", cell), tc.rule+" "+cell)
+		}
+		for _, cell := range tc.fails {
+			assert.False(t, rule.check("COL", cell), tc.rule+" "+cell)
+		}
+	}
+}
+
+func TestAssertUnique(t *testing.T) {
+	rule, err := parseAssertRule("unique")
+	assert.Nil(t, err)
+
+	assert.True(t, rule.check("A", "1"))
+	assert.True(t, rule.check("B", "1"))
+	assert.False(t, rule.check("A", "1"))
+	assert.True(t, rule.check("A", ""))
+	assert.True(t, rule.check("A", ""))
+}
+
+func TestAssertRuleErrors(t *testing.T) {
+	for _, rule := range []string{"unique:yes", "regex:([a-z]", "int:5", "decimal:a..b", "date:", "one-of:", "positive"} {
+		_, err := parseAssertRule(rule)
+		assert.NotNil(t, err, rule)
+	}
+}
+
+const assertInput = `ID,HOURS,TYPE
+1,7.5,FT
+2,30,PT
+2,,CASUAL`
+
+func TestAssertFlag(t *testing.T) {
+	recipe := `steps:
+  - op: assert
+    columns: [ID]
+    value: unique
+    options:
+      on-violation: flag
+  - op: assert
+    columns: [HOURS]
+    value: |
+      not-empty
+      decimal:0..24
+    options:
+      on-violation: flag
+  - op: assert
+    columns: [TYPE]
+    value: one-of:FT|PT
+    options:
+      on-violation: flag`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader(assertInput), *writer, operations, true, "", ""))
+	writer.Flush()
+
+	assert.Equal(t, `ID,HOURS,TYPE,_violations
+1,7.5,FT,
+2,30,PT,HOURS decimal:0..24
+2,,CASUAL,ID unique; HOURS not-empty; TYPE one-of:FT|PT
+`, result.String())
+}
+
+func TestAssertFail(t *testing.T) {
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	flags := map[string]flagval{
+		"assert": flagval{
+			active: true,
+			value:  "decimal:0..24\nnot-empty",
+		},
+	}
+	err := gumption(strings.NewReader(assertInput), *writer, []string{"HOURS"}, flags)
+	writer.Flush()
+
+	assert.EqualError(t, err, "2 rows failed assertions")
+	assert.Equal(t, assertInput+"\n", result.String())
+}
+
+func TestAssertReject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rejectsPath := path.Join(dir, "rejects.csv")
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	flags := map[string]flagval{
+		"assert": flagval{
+			active:  true,
+			value:   "one-of:FT|PT",
+			options: map[string]string{"on-violation": "reject"},
+		},
+		"rejects": flagval{
+			active: true,
+			value:  rejectsPath,
+		},
+	}
+	assert.Nil(t, gumption(strings.NewReader(assertInput), *writer, []string{"TYPE"}, flags))
+	writer.Flush()
+
+	assert.Equal(t, `ID,HOURS,TYPE
+1,7.5,FT
+2,30,PT
+`, result.String())
+
+	rejected, err := ioutil.ReadFile(rejectsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, `ID,HOURS,TYPE,gumption_reject_reason
+2,,CASUAL,failed TYPE one-of:FT|PT
+`, string(rejected))
+}
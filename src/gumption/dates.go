@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/paidright/datalab/util"
+)
+
+// dayOrder reports whether a format puts the day before the month ("dmy"),
+// after it ("mdy") or whether it can't tell ("")
+func dayOrder(format string) string {
+	days := []string{"DD", "%d", "%-d", "%e"}
+	months := []string{"MM", "SHORTMONTH", "%m", "%-m", "%b", "%h", "%B"}
+	if strings.Contains(format, "%") {
+		months = months[2:]
+	}
+
+	first := func(tokens []string) int {
+		best := -1
+		for _, token := range tokens {
+			if i := strings.Index(format, token); i >= 0 && (best < 0 || i < best) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	day, month := first(days), first(months)
+	switch {
+	case day < 0 || month < 0:
+		return ""
+	case day < month:
+		return "dmy"
+	}
+	return "mdy"
+}
+
+type inputLayout struct {
+	format string
+	layout string
+	order  string
+}
+
+func (l inputLayout) parse(cell string) (time.Time, bool) {
+	if l.format == util.ISOFormat {
+		return util.ParseISO(cell)
+	}
+	t, err := time.Parse(l.layout, cell)
+	return t, err == nil
+}
+
+// dateReformat reads dates that may be written in any of several layouts and
+// writes them out in one
+type dateReformat struct {
+	inputs []inputLayout
+	output string
+	// ambiguity is one of first, dmy, mdy or reject and decides between
+	// layouts that read the same cell as different dates
+	ambiguity string
+	onInvalid string
+}
+
+var ambiguityPolicies = []string{"first", "dmy", "mdy", "reject"}
+
+func newDateReformat(op *operation) (*dateReformat, error) {
+	parts := strings.Split(op.flag.value, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected an input and an output format eg: DD.MM.YYYY,YYYY-MM-DD")
+	}
+
+	d := dateReformat{
+		output:    util.DateLayout(parts[1]),
+		ambiguity: op.option("ambiguity", "first"),
+		onInvalid: op.option("on-invalid", "keep"),
+	}
+	// ISO8601 as the output format writes RFC 3339 timestamps
+	if parts[1] == util.ISOFormat {
+		d.output = time.RFC3339
+	}
+
+	for _, format := range strings.Split(parts[0], "|") {
+		d.inputs = append(d.inputs, inputLayout{
+			format: format,
+			layout: util.DateLayout(format),
+			order:  dayOrder(format),
+		})
+	}
+
+	if !util.Contains(d.ambiguity, ambiguityPolicies) {
+		return nil, fmt.Errorf("date ambiguity must be one of %s", strings.Join(ambiguityPolicies, ", "))
+	}
+	if !util.Contains(d.onInvalid, invalidModes) {
+		return nil, fmt.Errorf("on-invalid must be one of %s", strings.Join(invalidModes, ", "))
+	}
+
+	return &d, nil
+}
+
+// parse tries each input layout in turn and reports how it went
+func (d *dateReformat) parse(cell string) (time.Time, string, bool) {
+	matches := []time.Time{}
+	orders := []string{}
+	for _, input := range d.inputs {
+		if t, ok := input.parse(cell); ok {
+			matches = append(matches, t)
+			orders = append(orders, input.order)
+		}
+	}
+
+	if len(matches) == 0 {
+		return time.Time{}, "invalid", false
+	}
+
+	ambiguous := false
+	for _, t := range matches[1:] {
+		if !t.Equal(matches[0]) {
+			ambiguous = true
+		}
+	}
+	if !ambiguous {
+		return matches[0], "parsed", true
+	}
+
+	switch d.ambiguity {
+	case "reject":
+		return time.Time{}, "ambiguous", false
+	case "dmy", "mdy":
+		for i, order := range orders {
+			if order == d.ambiguity {
+				return matches[i], "ambiguous read as " + d.ambiguity, true
+			}
+		}
+	}
+	return matches[0], "ambiguous read as first match", true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/paidright/datalab/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDayOrder(t *testing.T) {
+	assert.Equal(t, "dmy", dayOrder("DD/MM/YYYY"))
+	assert.Equal(t, "mdy", dayOrder("MM/DD/YYYY"))
+	assert.Equal(t, "dmy", dayOrder("DD-SHORTMONTH-YY"))
+	assert.Equal(t, "mdy", dayOrder("%b %-d %Y"))
+	assert.Equal(t, "dmy", dayOrder("%d/%m/%Y"))
+	assert.Equal(t, "", dayOrder("YYYY"))
+	assert.Equal(t, "", dayOrder(util.ISOFormat))
+}
+
+func TestDateAmbiguity(t *testing.T) {
+	op := &operation{
+		name: "reformatDate",
+		flag: flagval{value: "MM/DD/YYYY|DD/MM/YYYY,YYYY-MM-DD"},
+	}
+
+	type ambiguityTest struct {
+		policy  string
+		cell    string
+		want    string
+		outcome string
+	}
+
+	tests := []ambiguityTest{
+		{"first", "01/02/2020", "2020-01-02", "ambiguous read as first match"},
+		{"dmy", "01/02/2020", "2020-02-01", "ambiguous read as dmy"},
+		{"mdy", "01/02/2020", "2020-01-02", "ambiguous read as mdy"},
+		{"reject", "01/02/2020", "", "ambiguous"},
+		{"reject", "13/02/2020", "2020-02-13", "parsed"},
+		{"dmy", "02/02/2020", "2020-02-02", "parsed"},
+	}
+
+	for _, tc := range tests {
+		op.flag.options = map[string]string{"ambiguity": tc.policy}
+		dates, err := newDateReformat(op)
+		assert.Nil(t, err)
+
+		parsed, outcome, ok := dates.parse(tc.cell)
+		assert.Equal(t, tc.outcome, outcome, tc.policy)
+		assert.Equal(t, tc.want != "", ok, tc.policy)
+		if ok {
+			assert.Equal(t, tc.want, parsed.Format(dates.output), tc.policy)
+		}
+	}
+}
+
+func TestReformatDateCandidates(t *testing.T) {
+	input := `ID,START
+1,01/02/2020
+2,2020-02-03
+3,4-Feb-20
+4,garbage
+5,`
+
+	type candidatesTest struct {
+		onInvalid string
+		want      string
+	}
+
+	tests := []candidatesTest{
+		{"keep", "ID,START\n1,2020-02-01\n2,2020-02-03\n3,2020-02-04\n4,garbage\n5,\n"},
+		{"blank", "ID,START\n1,2020-02-01\n2,2020-02-03\n3,2020-02-04\n4,\n5,\n"},
+		{"reject", "ID,START\n1,2020-02-01\n2,2020-02-03\n3,2020-02-04\n5,\n"},
+	}
+
+	for _, tc := range tests {
+		flags := map[string]flagval{
+			"reformatDate": flagval{
+				active: true,
+				value:  "%d/%m/%Y|ISO8601|%-d-%b-%y,YYYY-MM-DD",
+				options: map[string]string{
+					"on-invalid": tc.onInvalid,
+				},
+			},
+		}
+
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"START"}, flags))
+		writer.Flush()
+
+		assert.Equal(t, tc.want, result.String(), tc.onInvalid)
+	}
+}
+
+func TestDateAmbiguityReject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rejectsPath := path.Join(dir, "rejects.csv")
+
+	input := `ID,START
+1,01/02/2020
+2,13/02/2020
+3,garbage`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	// Ambiguous dates are rejected even though invalid ones are kept
+	flags := map[string]flagval{
+		"reformatDate": flagval{
+			active:  true,
+			value:   "MM/DD/YYYY|DD/MM/YYYY,YYYY-MM-DD",
+			options: map[string]string{"ambiguity": "reject"},
+		},
+		"rejects": flagval{
+			active: true,
+			value:  rejectsPath,
+		},
+	}
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"START"}, flags))
+	writer.Flush()
+
+	assert.Equal(t, `ID,START
+2,2020-02-13
+3,garbage
+`, result.String())
+
+	rejected, err := ioutil.ReadFile(rejectsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, `ID,START,gumption_reject_reason
+1,01/02/2020,ambiguous date
+`, string(rejected))
+}
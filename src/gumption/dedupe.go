@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paidright/datalab/util"
+)
+
+// dedupePolicies are the choices for --dedupe
+var dedupePolicies = []string{"first", "last"}
+
+const defaultMaxKeys = 1000000
+
+// flushBatch is how many rows --dedupe last hands over at a time once it has
+// seen them all
+const flushBatch = 1000
+
+// rowKey is a hash of the key columns of a row. Every key takes the same room
+// however long the values are.
+type rowKey [sha1.Size]byte
+
+func hashKey(columns []string, data map[string]string) rowKey {
+	values := []string{}
+	for _, col := range columns {
+		values = append(values, data[col])
+	}
+	return sha1.Sum([]byte(strings.Join(values, keySeparator)))
+}
+
+// keySet remembers the keys it has been given. Once more than limit are held
+// in memory they are merged into a sorted file on disk, which is searched for
+// keys not found in memory.
+type keySet struct {
+	limit  int
+	memory map[rowKey]bool
+	disk   *os.File
+	onDisk int64
+}
+
+func newKeySet(limit int) *keySet {
+	return &keySet{
+		limit:  limit,
+		memory: map[rowKey]bool{},
+	}
+}
+
+// add remembers a key, reporting whether it is new
+func (k *keySet) add(key rowKey) (bool, error) {
+	if k.memory[key] {
+		return false, nil
+	}
+	found, err := k.searchDisk(key)
+	if err != nil || found {
+		return false, err
+	}
+
+	k.memory[key] = true
+	if len(k.memory) >= k.limit {
+		return true, k.spill()
+	}
+	return true, nil
+}
+
+func (k *keySet) searchDisk(key rowKey) (bool, error) {
+	var err error
+	found := sort.Search(int(k.onDisk), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var stored rowKey
+		if _, err = k.disk.ReadAt(stored[:], int64(i)*int64(len(key))); err != nil {
+			return true
+		}
+		return bytes.Compare(stored[:], key[:]) >= 0
+	})
+	if err != nil || found == int(k.onDisk) {
+		return false, err
+	}
+
+	var stored rowKey
+	if _, err := k.disk.ReadAt(stored[:], int64(found)*int64(len(key))); err != nil {
+		return false, err
+	}
+	return stored == key, nil
+}
+
+// spill merges the keys held in memory into the file on disk
+func (k *keySet) spill() error {
+	keys := []rowKey{}
+	for key := range k.memory {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+
+	f, err := ioutil.TempFile("", "gumption_keys")
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(f)
+
+	var in *bufio.Reader
+	if k.disk != nil {
+		if _, err := k.disk.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		in = bufio.NewReader(k.disk)
+	}
+
+	var stored rowKey
+	loaded := false
+	remaining := k.onDisk
+	for remaining > 0 || len(keys) > 0 {
+		if remaining > 0 && !loaded {
+			if _, err := io.ReadFull(in, stored[:]); err != nil {
+				return err
+			}
+			loaded = true
+		}
+		if remaining > 0 && (len(keys) == 0 || bytes.Compare(stored[:], keys[0][:]) < 0) {
+			out.Write(stored[:])
+			loaded = false
+			remaining--
+			continue
+		}
+		out.Write(keys[0][:])
+		keys = keys[1:]
+	}
+
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	k.close()
+	k.disk = f
+	k.onDisk += int64(len(k.memory))
+	k.memory = map[rowKey]bool{}
+	return nil
+}
+
+func (k *keySet) close() {
+	if k.disk != nil {
+		k.disk.Close()
+		os.Remove(k.disk.Name())
+	}
+}
+
+// rowStore keeps rows on disk in the order they arrived, so --dedupe last can
+// look back over them once it has seen them all
+type rowStore struct {
+	headers []string
+	rows    *os.File
+	written *bufio.Writer
+	// offsets holds where each row starts in rows as 8 bytes
+	offsets *os.File
+	indexed *bufio.Writer
+	size    int64
+	count   int64
+	// kept marks the rows that are the last of their key, a bit per row
+	kept []byte
+
+	reader *csv.Reader
+	next   int64
+}
+
+func newRowStore(headers []string) (*rowStore, error) {
+	rows, err := ioutil.TempFile("", "gumption_rows")
+	if err != nil {
+		return nil, err
+	}
+	offsets, err := ioutil.TempFile("", "gumption_offsets")
+	if err != nil {
+		rows.Close()
+		os.Remove(rows.Name())
+		return nil, err
+	}
+	return &rowStore{
+		headers: append([]string{}, headers...),
+		rows:    rows,
+		written: bufio.NewWriter(rows),
+		offsets: offsets,
+		indexed: bufio.NewWriter(offsets),
+	}, nil
+}
+
+// encode lays a row out as the line number, the record as it was read and
+// the current cells
+func (s *rowStore) encode(line util.Line) []string {
+	fields := []string{strconv.Itoa(line.Number), strconv.Itoa(len(line.Record))}
+	fields = append(fields, line.Record...)
+	for _, header := range s.headers {
+		fields = append(fields, line.Data[header])
+	}
+	return fields
+}
+
+func (s *rowStore) decode(fields []string) (util.Line, error) {
+	number, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return util.Line{}, err
+	}
+	records, err := strconv.Atoi(fields[1])
+	if err != nil || len(fields) != 2+records+len(s.headers) {
+		return util.Line{}, fmt.Errorf("garbled stored row %d", number)
+	}
+
+	line := util.Line{
+		Number:  number,
+		Headers: s.headers,
+		Record:  fields[2 : 2+records],
+		Data:    map[string]string{},
+	}
+	for i, header := range s.headers {
+		line.Data[header] = fields[2+records+i]
+	}
+	return line, nil
+}
+
+func (s *rowStore) add(line util.Line) error {
+	buf := bytes.Buffer{}
+	w := csv.NewWriter(&buf)
+	if err := w.Write(s.encode(line)); err != nil {
+		return err
+	}
+	w.Flush()
+
+	offset := make([]byte, 8)
+	binary.BigEndian.PutUint64(offset, uint64(s.size))
+	if _, err := s.indexed.Write(offset); err != nil {
+		return err
+	}
+	if _, err := s.written.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	s.size += int64(buf.Len())
+	s.count++
+	return nil
+}
+
+// row reads back the row numbered i in the order they were added
+func (s *rowStore) row(i int64) (util.Line, error) {
+	offsets := make([]byte, 16)
+	n, err := s.offsets.ReadAt(offsets, i*8)
+	if err != nil && !(err == io.EOF && n == 8) {
+		return util.Line{}, err
+	}
+	start := int64(binary.BigEndian.Uint64(offsets[:8]))
+	end := s.size
+	if n == 16 {
+		end = int64(binary.BigEndian.Uint64(offsets[8:]))
+	}
+
+	raw := make([]byte, end-start)
+	if _, err := s.rows.ReadAt(raw, start); err != nil {
+		return util.Line{}, err
+	}
+	fields, err := csv.NewReader(bytes.NewReader(raw)).Read()
+	if err != nil {
+		return util.Line{}, err
+	}
+	return s.decode(fields)
+}
+
+// markLast works backwards through the rows, keeping the first of each key
+// it comes across, which is the last to arrive
+func (s *rowStore) markLast(columns []string, limit int) error {
+	if err := s.written.Flush(); err != nil {
+		return err
+	}
+	if err := s.indexed.Flush(); err != nil {
+		return err
+	}
+
+	keys := newKeySet(limit)
+	defer keys.close()
+
+	s.kept = make([]byte, (s.count+7)/8)
+	for i := s.count - 1; i >= 0; i-- {
+		line, err := s.row(i)
+		if err != nil {
+			return err
+		}
+		isNew, err := keys.add(hashKey(columns, line.Data))
+		if err != nil {
+			return err
+		}
+		if isNew {
+			s.kept[i/8] |= 1 << uint(i%8)
+		}
+	}
+
+	if _, err := s.rows.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.reader = csv.NewReader(bufio.NewReader(s.rows))
+	s.reader.FieldsPerRecord = -1
+	return nil
+}
+
+// read hands back the next stored row and whether it was marked as kept
+func (s *rowStore) read() (util.Line, bool, error) {
+	fields, err := s.reader.Read()
+	if err != nil {
+		return util.Line{}, false, err
+	}
+	line, err := s.decode(fields)
+	kept := s.kept[s.next/8]&(1<<uint(s.next%8)) != 0
+	s.next++
+	return line, kept, err
+}
+
+func (s *rowStore) close() {
+	s.rows.Close()
+	os.Remove(s.rows.Name())
+	s.offsets.Close()
+	os.Remove(s.offsets.Name())
+}
+
+// deduper drops rows whose key columns match a row it has already kept
+type deduper struct {
+	policy  string
+	limit   int
+	keys    *keySet
+	store   *rowStore
+	headers []string
+
+	duplicatesFile *os.File
+	duplicates     *csv.Writer
+}
+
+func newDeduper(op *operation, headers []string) (*deduper, error) {
+	d := deduper{
+		policy:  op.flag.value,
+		limit:   defaultMaxKeys,
+		headers: append([]string{}, headers...),
+	}
+
+	if !util.Contains(d.policy, dedupePolicies) {
+		return nil, fmt.Errorf("dedupe expects one of %s", strings.Join(dedupePolicies, ", "))
+	}
+
+	if v := op.option("max-keys", ""); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("max keys expects a number of keys eg: 1000000")
+		}
+		d.limit = limit
+	}
+
+	if path := op.option("duplicates", ""); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		d.duplicatesFile = f
+		d.duplicates = csv.NewWriter(f)
+		if err := d.duplicates.Write(d.headers); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.policy == "last" {
+		store, err := newRowStore(headers)
+		if err != nil {
+			return nil, err
+		}
+		d.store = store
+	} else {
+		d.keys = newKeySet(d.limit)
+	}
+
+	return &d, nil
+}
+
+// first keeps a row if no row with the same key came before it
+func (d *deduper) first(op *operation, line util.Line) ([]util.Line, error) {
+	isNew, err := d.keys.add(hashKey(op.columns, line.Data))
+	if err != nil {
+		return []util.Line{}, err
+	}
+	if isNew {
+		op.count("kept")
+		return []util.Line{line}, nil
+	}
+	op.count("duplicate")
+	return []util.Line{}, d.duplicate(line)
+}
+
+// last holds on to every row until the end of the input, as any of them
+// could be followed by a row with the same key
+func (d *deduper) last(line util.Line) ([]util.Line, error) {
+	return []util.Line{}, d.store.add(line)
+}
+
+func (d *deduper) duplicate(line util.Line) error {
+	if d.duplicates == nil {
+		return nil
+	}
+	values := []string{}
+	for _, header := range d.headers {
+		values = append(values, line.Data[header])
+	}
+	return d.duplicates.Write(values)
+}
+
+// flush hands over the rows --dedupe last kept, a batch at a time
+func (d *deduper) flush(op *operation) ([]util.Line, error) {
+	ready := []util.Line{}
+	if d.store == nil {
+		return ready, nil
+	}
+
+	if d.store.reader == nil {
+		if err := d.store.markLast(op.columns, d.limit); err != nil {
+			return ready, err
+		}
+	}
+
+	for d.store.next < d.store.count && len(ready) < flushBatch {
+		line, kept, err := d.store.read()
+		if err != nil {
+			return ready, err
+		}
+		if kept {
+			op.count("kept")
+			ready = append(ready, line)
+			continue
+		}
+		op.count("duplicate")
+		if err := d.duplicate(line); err != nil {
+			return ready, err
+		}
+	}
+	return ready, nil
+}
+
+func (d *deduper) finish() error {
+	if d.keys != nil {
+		d.keys.close()
+	}
+	if d.store != nil {
+		d.store.close()
+	}
+	if d.duplicates != nil {
+		d.duplicates.Flush()
+		if err := d.duplicates.Error(); err != nil {
+			return err
+		}
+		return d.duplicatesFile.Close()
+	}
+	return nil
+}
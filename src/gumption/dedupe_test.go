@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySet(t *testing.T) {
+	keys := newKeySet(3)
+	defer keys.close()
+
+	// Enough keys to spill to disk a few times over
+	for i := 0; i < 20; i++ {
+		isNew, err := keys.add(hashKey([]string{"ID"}, map[string]string{"ID": strconv.Itoa(i)}))
+		assert.Nil(t, err)
+		assert.True(t, isNew, i)
+	}
+	assert.Equal(t, int64(18), keys.onDisk)
+
+	for i := 0; i < 20; i++ {
+		isNew, err := keys.add(hashKey([]string{"ID"}, map[string]string{"ID": strconv.Itoa(i)}))
+		assert.Nil(t, err)
+		assert.False(t, isNew, i)
+	}
+}
+
+const dedupeInput = `EMP,DATE,HOURS
+1,2020-01-01,7
+2,2020-01-01,8
+1,2020-01-01,7
+1,2020-01-01,6
+2,2020-01-02,8`
+
+func TestDedupe(t *testing.T) {
+	for _, tc := range []struct {
+		policy   string
+		columns  []string
+		maxKeys  string
+		expected string
+	}{
+		{"first", []string{}, "", "EMP,DATE,HOURS\n1,2020-01-01,7\n2,2020-01-01,8\n1,2020-01-01,6\n2,2020-01-02,8\n"},
+		{"first", []string{"EMP", "DATE"}, "", "EMP,DATE,HOURS\n1,2020-01-01,7\n2,2020-01-01,8\n2,2020-01-02,8\n"},
+		{"last", []string{"EMP", "DATE"}, "", "EMP,DATE,HOURS\n2,2020-01-01,8\n1,2020-01-01,6\n2,2020-01-02,8\n"},
+		{"last", []string{"EMP"}, "", "EMP,DATE,HOURS\n1,2020-01-01,6\n2,2020-01-02,8\n"},
+		{"first", []string{"EMP", "DATE"}, "1", "EMP,DATE,HOURS\n1,2020-01-01,7\n2,2020-01-01,8\n2,2020-01-02,8\n"},
+		{"last", []string{"EMP", "DATE"}, "1", "EMP,DATE,HOURS\n2,2020-01-01,8\n1,2020-01-01,6\n2,2020-01-02,8\n"},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		flags := map[string]flagval{
+			"dedupe": flagval{
+				active:  true,
+				value:   tc.policy,
+				options: map[string]string{"max-keys": tc.maxKeys},
+			},
+		}
+		assert.Nil(t, gumption(strings.NewReader(dedupeInput), *writer, tc.columns, flags))
+		writer.Flush()
+
+		assert.Equal(t, tc.expected, result.String(), tc.policy, tc.columns, tc.maxKeys)
+	}
+}
+
+func TestDedupeDuplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	duplicatesPath := path.Join(dir, "duplicates.csv")
+
+	recipe := `steps:
+  - op: trim-whitespace
+  - op: dedupe
+    columns: [EMP, DATE]
+    value: last
+    options:
+      duplicates: ` + duplicatesPath + `
+  - op: sequence
+    value: LINE`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	input := `EMP,DATE,HOURS
+1, 2020-01-01,7
+2,2020-01-01,8
+1,2020-01-01 ,6`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader(input), *writer, operations, true, "", ""))
+	writer.Flush()
+
+	// Rows held back until the end still go through the steps after
+	assert.Equal(t, `EMP,DATE,HOURS,LINE
+2,2020-01-01,8,1
+1,2020-01-01,6,2
+`, result.String())
+
+	duplicates, err := ioutil.ReadFile(duplicatesPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "EMP,DATE,HOURS\n1,2020-01-01,7\n", string(duplicates))
+}
+
+func TestDedupeErrors(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"policy": "middle"},
+		{"policy": "first", "max-keys": "none"},
+		{"policy": "last", "max-keys": "0"},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		flags := map[string]flagval{
+			"dedupe": flagval{active: true, value: options["policy"], options: options},
+		}
+		assert.NotNil(t, gumption(strings.NewReader(dedupeInput), *writer, []string{}, flags), options)
+	}
+
+	_, err := loadRecipe(strings.NewReader("steps:\n  - op: dedupe\n    value: first\n    if: EMP = 1"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paidright/datalab/util"
+)
+
+const defaultDurationLayout = "YYYY-MM-DD hh:mm:ss"
+
+var durationUnits = []string{"decimal-hours", "hours", "minutes"}
+
+// durationCalc works out the time between two columns, less any break
+type durationCalc struct {
+	start, end, target string
+	breakColumn        string
+	inputs             []inputLayout
+	unit               string
+	precision          int
+}
+
+func newDurationCalc(op *operation, headers []string) (*durationCalc, error) {
+	parts := strings.Split(op.flag.value, ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("expected a start column, an end column and a new column eg: START,END,HOURS")
+	}
+
+	precision, err := strconv.Atoi(op.option("precision", "2"))
+	if err != nil || precision < 0 {
+		return nil, fmt.Errorf("precision must be a whole number of decimal places")
+	}
+
+	d := durationCalc{
+		start:       parts[0],
+		end:         parts[1],
+		target:      parts[2],
+		breakColumn: op.option("break-column", ""),
+		unit:        op.option("unit", "decimal-hours"),
+		precision:   precision,
+	}
+
+	for _, format := range strings.Split(op.option("layout", defaultDurationLayout), "|") {
+		d.inputs = append(d.inputs, inputLayout{
+			format: format,
+			layout: util.DateLayout(format),
+		})
+	}
+
+	for _, col := range []string{d.start, d.end, d.breakColumn} {
+		if col != "" && !util.Contains(col, headers) {
+			return nil, fmt.Errorf("unknown column %s in duration", col)
+		}
+	}
+	if !util.Contains(d.unit, durationUnits) {
+		return nil, fmt.Errorf("duration unit must be one of %s", strings.Join(durationUnits, ", "))
+	}
+
+	return &d, nil
+}
+
+func (d *durationCalc) parse(cell string) (time.Time, bool) {
+	for _, input := range d.inputs {
+		if t, ok := input.parse(cell); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// calculate returns the formatted duration for a row along with what happened
+func (d *durationCalc) calculate(row map[string]string) (string, string) {
+	if strings.TrimSpace(row[d.start]) == "" || strings.TrimSpace(row[d.end]) == "" {
+		return "", "blank"
+	}
+
+	start, ok := d.parse(row[d.start])
+	if !ok {
+		return "", "invalid start"
+	}
+	end, ok := d.parse(row[d.end])
+	if !ok {
+		return "", "invalid end"
+	}
+
+	outcome := "calculated"
+
+	// Times without dates that go backwards are shifts that cross midnight
+	if start.Year() == 0 && end.Year() == 0 && end.Before(start) {
+		end = end.Add(24 * time.Hour)
+		outcome = "crossed midnight"
+	}
+	if end.Before(start) {
+		return "", "end before start"
+	}
+
+	seconds := new(big.Rat).SetInt64(int64(end.Sub(start) / time.Second))
+
+	if d.breakColumn != "" && strings.TrimSpace(row[d.breakColumn]) != "" {
+		minutes, ok := util.ParseDecimal(row[d.breakColumn])
+		if !ok {
+			return "", "invalid break"
+		}
+		seconds.Sub(seconds, new(big.Rat).Mul(minutes, big.NewRat(60, 1)))
+		if seconds.Sign() < 0 {
+			return "", "break longer than shift"
+		}
+	}
+
+	switch d.unit {
+	case "minutes":
+		return util.FormatDecimal(new(big.Rat).Quo(seconds, big.NewRat(60, 1))), outcome
+	case "hours":
+		minutes := util.RoundDecimalMode(new(big.Rat).Quo(seconds, big.NewRat(60, 1)), 0, util.RoundHalfEven).Num().Int64()
+		return fmt.Sprintf("%d:%02d", minutes/60, minutes%60), outcome
+	}
+
+	hours := new(big.Rat).Quo(seconds, big.NewRat(60*60, 1))
+	return util.RoundDecimalMode(hours, d.precision, util.RoundHalfEven).FloatString(d.precision), outcome
+}
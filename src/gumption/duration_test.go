@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuration(t *testing.T) {
+	type durationTest struct {
+		options map[string]string
+		input   string
+		want    string
+	}
+
+	tests := []durationTest{
+		{
+			input: `START,END
+2020-02-01 09:00:00,2020-02-01 17:30:00
+2020-02-01 22:00:00,2020-02-02 06:20:00
+2020-02-01 09:00:00,2020-01-31 17:30:00
+garbage,2020-02-01 17:30:00
+,2020-02-01 17:30:00`,
+			want: `START,END,HOURS
+2020-02-01 09:00:00,2020-02-01 17:30:00,8.50
+2020-02-01 22:00:00,2020-02-02 06:20:00,8.33
+2020-02-01 09:00:00,2020-01-31 17:30:00,
+garbage,2020-02-01 17:30:00,
+,2020-02-01 17:30:00,
+`,
+		},
+		{
+			options: map[string]string{
+				"layout":       "hh:mm|%-I:%M %p",
+				"unit":         "hours",
+				"break-column": "BREAK",
+			},
+			input: `START,END,BREAK
+09:00,17:30,30
+22:00,06:15,
+10:00 PM,6:15 AM,45
+09:00,09:30,60`,
+			want: `START,END,BREAK,HOURS
+09:00,17:30,30,8:00
+22:00,06:15,,8:15
+10:00 PM,6:15 AM,45,7:30
+09:00,09:30,60,
+`,
+		},
+		{
+			options: map[string]string{
+				"layout": "ISO8601",
+				"unit":   "minutes",
+			},
+			input: `START,END
+2020-02-01T09:00:00+10:00,2020-02-01T09:45:30+10:00
+2020-02-01T09:00:00+10:00,2020-02-01T09:00:00+09:30`,
+			want: `START,END,HOURS
+2020-02-01T09:00:00+10:00,2020-02-01T09:45:30+10:00,45.5
+2020-02-01T09:00:00+10:00,2020-02-01T09:00:00+09:30,30
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		flags := map[string]flagval{
+			"duration": flagval{
+				active:  true,
+				value:   "START,END,HOURS",
+				options: tc.options,
+			},
+		}
+
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.Nil(t, gumption(strings.NewReader(tc.input), *writer, []string{}, flags))
+		writer.Flush()
+
+		assert.Equal(t, tc.want, result.String())
+	}
+}
+
+func TestDurationErrors(t *testing.T) {
+	for _, f := range []flagval{
+		{active: true, value: "START,END"},
+		{active: true, value: "START,MISSING,HOURS"},
+		{active: true, value: "START,END,HOURS", options: map[string]string{"break-column": "MISSING"}},
+		{active: true, value: "START,END,HOURS", options: map[string]string{"unit": "fortnights"}},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.NotNil(t, gumption(strings.NewReader("START,END\n1,2"), *writer, []string{}, map[string]flagval{"duration": f}))
+	}
+}
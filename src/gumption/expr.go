@@ -0,0 +1,939 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/paidright/datalab/util"
+)
+
+// An expression program is a list of assignments such as
+//
+//   FULL_NAME = upper(FIRST) + " " + LAST; TOTAL = round(RATE * HOURS, 2)
+//
+// Columns are referred to by bare name, or wrapped in backticks if the name
+// has spaces or punctuation in it. Cells always start out as strings and are
+// converted as needed by operators and functions.
+
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindNumber
+	kindBool
+	kindDate
+)
+
+type value struct {
+	kind valueKind
+	str  string
+	num  *big.Rat
+	b    bool
+	t    time.Time
+}
+
+func stringValue(s string) value {
+	return value{kind: kindString, str: s}
+}
+
+func numberValue(n *big.Rat) value {
+	return value{kind: kindNumber, num: n}
+}
+
+func boolValue(b bool) value {
+	return value{kind: kindBool, b: b}
+}
+
+func dateValue(t time.Time) value {
+	return value{kind: kindDate, t: t}
+}
+
+func (v value) String() string {
+	switch v.kind {
+	case kindNumber:
+		return util.FormatDecimal(v.num)
+	case kindBool:
+		if v.b {
+			return "true"
+		}
+		return "false"
+	case kindDate:
+		if v.t.Hour() == 0 && v.t.Minute() == 0 && v.t.Second() == 0 && v.t.Nanosecond() == 0 {
+			return v.t.Format("2006-01-02")
+		}
+		return v.t.Format("2006-01-02 15:04:05")
+	default:
+		return v.str
+	}
+}
+
+func (v value) isEmpty() bool {
+	return v.kind == kindString && v.str == ""
+}
+
+func (v value) asNumber() (*big.Rat, error) {
+	switch v.kind {
+	case kindNumber:
+		return v.num, nil
+	case kindString:
+		if n, ok := util.ParseDecimal(v.str); ok {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot use %q as a number", v.String())
+}
+
+func (v value) asBool() (bool, error) {
+	switch v.kind {
+	case kindBool:
+		return v.b, nil
+	case kindString:
+		switch strings.ToLower(v.str) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	case kindNumber:
+		return v.num.Sign() != 0, nil
+	}
+	return false, fmt.Errorf("cannot use %q as true or false", v.String())
+}
+
+func (v value) asDate() (time.Time, error) {
+	switch v.kind {
+	case kindDate:
+		return v.t, nil
+	case kindString:
+		if t, ok := util.ParseISO(v.str); ok {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot use %q as a date", v.String())
+}
+
+func (v value) isNumeric() bool {
+	_, err := v.asNumber()
+	return err == nil
+}
+
+type node interface {
+	eval(row map[string]string) (value, error)
+}
+
+type literal struct {
+	v value
+}
+
+func (n literal) eval(row map[string]string) (value, error) {
+	return n.v, nil
+}
+
+type columnRef struct {
+	name string
+}
+
+func (n columnRef) eval(row map[string]string) (value, error) {
+	return stringValue(row[n.name]), nil
+}
+
+type unaryOp struct {
+	op      string
+	operand node
+}
+
+func (n unaryOp) eval(row map[string]string) (value, error) {
+	v, err := n.operand.eval(row)
+	if err != nil {
+		return v, err
+	}
+
+	if n.op == "not" {
+		b, err := v.asBool()
+		return boolValue(!b), err
+	}
+
+	num, err := v.asNumber()
+	if err != nil {
+		return v, err
+	}
+	return numberValue(new(big.Rat).Neg(num)), nil
+}
+
+type binaryOp struct {
+	op          string
+	left, right node
+}
+
+func (n binaryOp) eval(row map[string]string) (value, error) {
+	left, err := n.left.eval(row)
+	if err != nil {
+		return left, err
+	}
+
+	// and/or short circuit so that the right hand side may guard against
+	// garbage in the left
+	if n.op == "and" || n.op == "or" {
+		l, err := left.asBool()
+		if err != nil {
+			return left, err
+		}
+		if (n.op == "and" && !l) || (n.op == "or" && l) {
+			return boolValue(l), nil
+		}
+		right, err := n.right.eval(row)
+		if err != nil {
+			return right, err
+		}
+		r, err := right.asBool()
+		return boolValue(r), err
+	}
+
+	right, err := n.right.eval(row)
+	if err != nil {
+		return right, err
+	}
+
+	switch n.op {
+	case "+":
+		if left.isNumeric() && right.isNumeric() {
+			return arithmetic(n.op, left, right)
+		}
+		return stringValue(left.String() + right.String()), nil
+	case "-", "*", "/", "%":
+		return arithmetic(n.op, left, right)
+	case "=", "==", "!=", "<", "<=", ">", ">=":
+		c, err := compare(left, right)
+		if err != nil {
+			return left, err
+		}
+		switch n.op {
+		case "!=":
+			return boolValue(c != 0), nil
+		case "<":
+			return boolValue(c < 0), nil
+		case "<=":
+			return boolValue(c <= 0), nil
+		case ">":
+			return boolValue(c > 0), nil
+		case ">=":
+			return boolValue(c >= 0), nil
+		default:
+			return boolValue(c == 0), nil
+		}
+	}
+
+	return left, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func arithmetic(op string, left value, right value) (value, error) {
+	l, err := left.asNumber()
+	if err != nil {
+		return left, err
+	}
+	r, err := right.asNumber()
+	if err != nil {
+		return right, err
+	}
+
+	switch op {
+	case "+":
+		return numberValue(new(big.Rat).Add(l, r)), nil
+	case "-":
+		return numberValue(new(big.Rat).Sub(l, r)), nil
+	case "*":
+		return numberValue(new(big.Rat).Mul(l, r)), nil
+	}
+
+	if r.Sign() == 0 {
+		return left, fmt.Errorf("division by zero")
+	}
+
+	quotient := new(big.Rat).Quo(l, r)
+	if op == "/" {
+		return numberValue(quotient), nil
+	}
+
+	// Remainder truncates towards zero, same as Go
+	whole := new(big.Int).Quo(quotient.Num(), quotient.Denom())
+	return numberValue(new(big.Rat).Sub(l, new(big.Rat).Mul(r, new(big.Rat).SetInt(whole)))), nil
+}
+
+// compare orders two values. Numbers compare numerically, dates
+// chronologically and anything else as plain strings.
+func compare(left value, right value) (int, error) {
+	if left.kind == kindDate || right.kind == kindDate {
+		l, err := left.asDate()
+		if err != nil {
+			return 0, err
+		}
+		r, err := right.asDate()
+		if err != nil {
+			return 0, err
+		}
+		if l.Before(r) {
+			return -1, nil
+		}
+		if l.After(r) {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	if left.kind == kindBool || right.kind == kindBool {
+		l, err := left.asBool()
+		if err != nil {
+			return 0, err
+		}
+		r, err := right.asBool()
+		if err != nil {
+			return 0, err
+		}
+		if l == r {
+			return 0, nil
+		}
+		if r {
+			return -1, nil
+		}
+		return 1, nil
+	}
+
+	if left.isNumeric() && right.isNumeric() {
+		l, _ := left.asNumber()
+		r, _ := right.asNumber()
+		return l.Cmp(r), nil
+	}
+
+	return strings.Compare(left.String(), right.String()), nil
+}
+
+type regexMatch struct {
+	left    node
+	pattern node
+	re      *regexp.Regexp
+	negate  bool
+}
+
+func (n regexMatch) eval(row map[string]string) (value, error) {
+	left, err := n.left.eval(row)
+	if err != nil {
+		return left, err
+	}
+
+	re := n.re
+	if re == nil {
+		pattern, err := n.pattern.eval(row)
+		if err != nil {
+			return pattern, err
+		}
+		re, err = regexp.Compile(pattern.String())
+		if err != nil {
+			return pattern, err
+		}
+	}
+
+	return boolValue(re.MatchString(left.String()) != n.negate), nil
+}
+
+type inList struct {
+	left   node
+	items  []node
+	negate bool
+}
+
+func (n inList) eval(row map[string]string) (value, error) {
+	left, err := n.left.eval(row)
+	if err != nil {
+		return left, err
+	}
+
+	for _, item := range n.items {
+		v, err := item.eval(row)
+		if err != nil {
+			return v, err
+		}
+		c, err := compare(left, v)
+		if err == nil && c == 0 {
+			return boolValue(!n.negate), nil
+		}
+	}
+
+	return boolValue(n.negate), nil
+}
+
+// emptyTest treats cells holding nothing but whitespace as empty
+type emptyTest struct {
+	operand node
+	negate  bool
+}
+
+func (n emptyTest) eval(row map[string]string) (value, error) {
+	v, err := n.operand.eval(row)
+	if err != nil {
+		return v, err
+	}
+	empty := v.kind == kindString && strings.TrimSpace(v.str) == ""
+	return boolValue(empty != n.negate), nil
+}
+
+type call struct {
+	name string
+	fn   exprFunc
+	args []node
+}
+
+func (n call) eval(row map[string]string) (value, error) {
+	args := []value{}
+	for _, arg := range n.args {
+		v, err := arg.eval(row)
+		if err != nil {
+			return v, err
+		}
+		args = append(args, v)
+	}
+
+	v, err := n.fn.call(args)
+	if err != nil {
+		return v, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, nil
+}
+
+// lazyCall is for functions such as if and coalesce which only evaluate the
+// arguments they need
+type lazyCall struct {
+	name string
+	fn   func(row map[string]string, args []node) (value, error)
+	args []node
+}
+
+func (n lazyCall) eval(row map[string]string) (value, error) {
+	v, err := n.fn(row, n.args)
+	if err != nil {
+		return v, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, nil
+}
+
+type assignment struct {
+	target string
+	expr   node
+}
+
+type program struct {
+	assignments []assignment
+	// refs are the columns read by the program
+	refs []string
+}
+
+// targets lists the columns written by the program in the order they are first
+// assigned
+func (p program) targets() []string {
+	targets := []string{}
+	for _, a := range p.assignments {
+		targets = append(targets, a.target)
+	}
+	return util.Uniq(targets)
+}
+
+// validate checks that every column read by the program is either present in
+// the headers or assigned before it is read
+func (p program) validate(headers []string) error {
+	known := append([]string{}, headers...)
+	for _, a := range p.assignments {
+		for _, ref := range columnRefs(a.expr) {
+			if !util.Contains(ref, known) {
+				return fmt.Errorf("unknown column %s", ref)
+			}
+		}
+		known = append(known, a.target)
+	}
+	return nil
+}
+
+// run evaluates each assignment in turn, writing the results into row. An
+// assignment that fails leaves its target as it was and is handed to failed,
+// then the rest carry on.
+func (p program) run(row map[string]string, failed func(target string, err error)) {
+	for _, a := range p.assignments {
+		v, err := a.expr.eval(row)
+		if err != nil {
+			failed(a.target, err)
+			continue
+		}
+		row[a.target] = v.String()
+	}
+}
+
+func columnRefs(n node) []string {
+	switch n := n.(type) {
+	case columnRef:
+		return []string{n.name}
+	case unaryOp:
+		return columnRefs(n.operand)
+	case binaryOp:
+		return append(columnRefs(n.left), columnRefs(n.right)...)
+	case call:
+		refs := []string{}
+		for _, arg := range n.args {
+			refs = append(refs, columnRefs(arg)...)
+		}
+		return refs
+	case lazyCall:
+		refs := []string{}
+		for _, arg := range n.args {
+			refs = append(refs, columnRefs(arg)...)
+		}
+		return refs
+	case regexMatch:
+		return append(columnRefs(n.left), columnRefs(n.pattern)...)
+	case inList:
+		refs := columnRefs(n.left)
+		for _, item := range n.items {
+			refs = append(refs, columnRefs(item)...)
+		}
+		return refs
+	case emptyTest:
+		return columnRefs(n.operand)
+	}
+	return []string{}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokColumn
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func tokenise(input string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			quote := r
+			i++
+			str := []rune{}
+			for {
+				if i >= len(runes) {
+					return tokens, fmt.Errorf("unterminated string starting at %d", start)
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					str = append(str, runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == quote {
+					i++
+					break
+				}
+				str = append(str, runes[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokString, text: string(str), pos: start})
+		case r == '`':
+			i++
+			for i < len(runes) && runes[i] != '`' {
+				i++
+			}
+			if i >= len(runes) {
+				return tokens, fmt.Errorf("unterminated column name starting at %d", start)
+			}
+			tokens = append(tokens, token{kind: tokColumn, text: string(runes[start+1 : i]), pos: start})
+			i++
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i]), pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i]), pos: start})
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				two := string(runes[i : i+2])
+				switch two {
+				case "==", "!=", "<=", ">=", "=~", "!~":
+					op = two
+				}
+			}
+			if !strings.Contains("+-*/%()=<>!,;~", string(r)) && len(op) == 1 {
+				return tokens, fmt.Errorf("unexpected %q at %d", r, start)
+			}
+			i += len([]rune(op))
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: start})
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	return t.kind == tokOp && util.Contains(t.text, ops)
+}
+
+func (p *parser) isKeyword(words ...string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && util.Contains(t.text, words)
+}
+
+// isKeywordAfter looks one token further ahead than isKeyword
+func (p *parser) isKeywordAfter(word string) bool {
+	if p.pos+1 >= len(p.tokens) {
+		return false
+	}
+	t := p.tokens[p.pos+1]
+	return t.kind == tokIdent && t.text == word
+}
+
+func (p *parser) expect(op string) error {
+	t := p.next()
+	if t.kind != tokOp || t.text != op {
+		return fmt.Errorf("expected %s at %d", op, t.pos)
+	}
+	return nil
+}
+
+func parseProgram(input string) (program, error) {
+	tokens, err := tokenise(input)
+	if err != nil {
+		return program{}, err
+	}
+
+	p := parser{tokens: tokens}
+	prog := program{}
+
+	for p.peek().kind != tokEOF {
+		if p.isOp(";") {
+			p.next()
+			continue
+		}
+
+		target := p.next()
+		if target.kind != tokIdent && target.kind != tokColumn {
+			return prog, fmt.Errorf("expected a column to assign to at %d", target.pos)
+		}
+		if err := p.expect("="); err != nil {
+			return prog, err
+		}
+
+		expr, err := p.parseExpr()
+		if err != nil {
+			return prog, err
+		}
+
+		prog.assignments = append(prog.assignments, assignment{target: target.text, expr: expr})
+		prog.refs = append(prog.refs, columnRefs(expr)...)
+
+		if !p.isOp(";") && p.peek().kind != tokEOF {
+			return prog, fmt.Errorf("unexpected %q at %d", p.peek().text, p.peek().pos)
+		}
+	}
+
+	if len(prog.assignments) == 0 {
+		return prog, fmt.Errorf("no assignments found")
+	}
+
+	prog.refs = util.Uniq(prog.refs)
+
+	return prog, nil
+}
+
+// parseExpression parses a single expression that isn't assigned anywhere
+func parseExpression(input string) (node, error) {
+	tokens, err := tokenise(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", p.peek().text, p.peek().pos)
+	}
+	return expr, nil
+}
+
+func (p *parser) parseExpr() (node, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isKeyword("not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unaryOp{op: "not", operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("=", "==", "!=", "<", "<=", ">", ">=") {
+		op := p.next().text
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return binaryOp{op: op, left: left, right: right}, nil
+	}
+
+	if p.isOp("=~", "!~") {
+		negate := p.next().text == "!~"
+		pattern, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		match := regexMatch{left: left, pattern: pattern, negate: negate}
+		if lit, ok := pattern.(literal); ok {
+			match.re, err = regexp.Compile(lit.v.String())
+			if err != nil {
+				return nil, err
+			}
+		}
+		return match, nil
+	}
+
+	if p.isKeyword("in") || (p.isKeyword("not") && p.isKeywordAfter("in")) {
+		negate := p.next().text == "not"
+		if negate {
+			p.next()
+		}
+		items, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return inList{left: left, items: items, negate: negate}, nil
+	}
+
+	if p.isKeyword("is") {
+		p.next()
+		negate := p.isKeyword("not")
+		if negate {
+			p.next()
+		}
+		if !p.isKeyword("empty") {
+			return nil, fmt.Errorf("expected empty at %d", p.peek().pos)
+		}
+		p.next()
+		return emptyTest{operand: left, negate: negate}, nil
+	}
+
+	return left, nil
+}
+
+// parseList reads a bracketed, comma separated list of expressions
+func (p *parser) parseList() ([]node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	items := []node{}
+	for !p.isOp(")") {
+		item, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+
+	return items, p.expect(")")
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+", "-") {
+		op := p.next().text
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*", "/", "%") {
+		op := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryOp{op: "-", operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokString:
+		return literal{v: stringValue(t.text)}, nil
+	case tokNumber:
+		n, ok := util.ParseDecimal(t.text)
+		if !ok {
+			return nil, fmt.Errorf("bad number %s at %d", t.text, t.pos)
+		}
+		return literal{v: numberValue(n)}, nil
+	case tokColumn:
+		return columnRef{name: t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literal{v: boolValue(true)}, nil
+		case "false":
+			return literal{v: boolValue(false)}, nil
+		}
+		if p.isOp("(") {
+			return p.parseCall(t)
+		}
+		return columnRef{name: t.text}, nil
+	case tokOp:
+		if t.text == "(" {
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	args, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+
+	if lazy, ok := lazyFuncs[name.text]; ok {
+		if len(args) < lazy.minArgs || (lazy.maxArgs >= 0 && len(args) > lazy.maxArgs) {
+			return nil, fmt.Errorf("wrong number of arguments to %s at %d", name.text, name.pos)
+		}
+		return lazyCall{name: name.text, fn: lazy.fn, args: args}, nil
+	}
+
+	fn, ok := exprFuncs[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at %d", name.text, name.pos)
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments to %s at %d", name.text, name.pos)
+	}
+
+	return call{name: name.text, fn: fn, args: args}, nil
+}
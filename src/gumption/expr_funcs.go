@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/paidright/datalab/util"
+)
+
+type exprFunc struct {
+	minArgs int
+	// maxArgs of -1 means any number of arguments
+	maxArgs int
+	call    func(args []value) (value, error)
+}
+
+type lazyFunc struct {
+	minArgs int
+	maxArgs int
+	fn      func(row map[string]string, args []node) (value, error)
+}
+
+var exprFuncs = map[string]exprFunc{
+	"upper": {1, 1, func(args []value) (value, error) {
+		return stringValue(strings.ToUpper(args[0].String())), nil
+	}},
+	"lower": {1, 1, func(args []value) (value, error) {
+		return stringValue(strings.ToLower(args[0].String())), nil
+	}},
+	"trim": {1, 1, func(args []value) (value, error) {
+		return stringValue(strings.TrimSpace(args[0].String())), nil
+	}},
+	"len": {1, 1, func(args []value) (value, error) {
+		return numberValue(new(big.Rat).SetInt64(int64(len([]rune(args[0].String()))))), nil
+	}},
+	"substr": {2, 3, substr},
+	"replace": {3, 3, func(args []value) (value, error) {
+		return stringValue(strings.ReplaceAll(args[0].String(), args[1].String(), args[2].String())), nil
+	}},
+	"concat": {1, -1, func(args []value) (value, error) {
+		parts := []string{}
+		for _, arg := range args {
+			parts = append(parts, arg.String())
+		}
+		return stringValue(strings.Join(parts, "")), nil
+	}},
+	"contains": {2, 2, func(args []value) (value, error) {
+		return boolValue(strings.Contains(args[0].String(), args[1].String())), nil
+	}},
+	"starts_with": {2, 2, func(args []value) (value, error) {
+		return boolValue(strings.HasPrefix(args[0].String(), args[1].String())), nil
+	}},
+	"ends_with": {2, 2, func(args []value) (value, error) {
+		return boolValue(strings.HasSuffix(args[0].String(), args[1].String())), nil
+	}},
+	"string": {1, 1, func(args []value) (value, error) {
+		return stringValue(args[0].String()), nil
+	}},
+	"number": {1, 1, func(args []value) (value, error) {
+		n, err := args[0].asNumber()
+		return numberValue(n), err
+	}},
+	"round": {1, 3, func(args []value) (value, error) {
+		n, err := args[0].asNumber()
+		if err != nil {
+			return args[0], err
+		}
+		places, err := intArg(args, 1, 0)
+		if err != nil || places < 0 {
+			return args[0], fmt.Errorf("places must be a whole number of at least zero")
+		}
+		mode := util.RoundHalfUp
+		if len(args) > 2 {
+			mode = util.RoundingMode(args[2].String())
+			if !util.Contains(string(mode), util.RoundingModes) {
+				return args[2], fmt.Errorf("rounding must be one of %s", strings.Join(util.RoundingModes, ", "))
+			}
+		}
+		return numberValue(util.RoundDecimalMode(n, places, mode)), nil
+	}},
+	"floor": {1, 1, func(args []value) (value, error) {
+		return integerPart(args[0], -1)
+	}},
+	"ceil": {1, 1, func(args []value) (value, error) {
+		return integerPart(args[0], 1)
+	}},
+	"abs": {1, 1, func(args []value) (value, error) {
+		n, err := args[0].asNumber()
+		if err != nil {
+			return args[0], err
+		}
+		return numberValue(new(big.Rat).Abs(n)), nil
+	}},
+	"sum": {1, -1, func(args []value) (value, error) {
+		total := new(big.Rat)
+		for _, arg := range args {
+			if arg.isEmpty() {
+				continue
+			}
+			n, err := arg.asNumber()
+			if err != nil {
+				return arg, err
+			}
+			total.Add(total, n)
+		}
+		return numberValue(total), nil
+	}},
+	"clamp": {3, 3, clamp},
+	"convert": {3, 3, func(args []value) (value, error) {
+		n, err := args[0].asNumber()
+		if err != nil {
+			return args[0], err
+		}
+		converted, err := convertUnits(n, args[1].String(), args[2].String())
+		if err != nil {
+			return args[0], err
+		}
+		return numberValue(converted), nil
+	}},
+	"min": {1, -1, func(args []value) (value, error) {
+		return extreme(args, -1)
+	}},
+	"max": {1, -1, func(args []value) (value, error) {
+		return extreme(args, 1)
+	}},
+	"date": {1, 1, func(args []value) (value, error) {
+		t, err := args[0].asDate()
+		return dateValue(t), err
+	}},
+	"parse_date": {2, 2, func(args []value) (value, error) {
+		t, err := time.Parse(util.DateLayout(args[1].String()), args[0].String())
+		if err != nil {
+			return args[0], fmt.Errorf("cannot parse %q as %s", args[0].String(), args[1].String())
+		}
+		return dateValue(t), nil
+	}},
+	"format_date": {2, 2, func(args []value) (value, error) {
+		t, err := args[0].asDate()
+		if err != nil {
+			return args[0], err
+		}
+		return stringValue(t.Format(util.DateLayout(args[1].String()))), nil
+	}},
+	"add_days": {2, 2, func(args []value) (value, error) {
+		t, err := args[0].asDate()
+		if err != nil {
+			return args[0], err
+		}
+		days, err := intArg(args, 1, 0)
+		if err != nil {
+			return args[1], err
+		}
+		return dateValue(t.AddDate(0, 0, days)), nil
+	}},
+	"days_between": {2, 2, func(args []value) (value, error) {
+		from, err := args[0].asDate()
+		if err != nil {
+			return args[0], err
+		}
+		to, err := args[1].asDate()
+		if err != nil {
+			return args[1], err
+		}
+		days := new(big.Rat).SetFrac64(int64(to.Sub(from)/time.Second), 24*60*60)
+		return numberValue(days), nil
+	}},
+}
+
+var lazyFuncs = map[string]lazyFunc{
+	"if": {3, 3, func(row map[string]string, args []node) (value, error) {
+		cond, err := args[0].eval(row)
+		if err != nil {
+			return cond, err
+		}
+		b, err := cond.asBool()
+		if err != nil {
+			return cond, err
+		}
+		if b {
+			return args[1].eval(row)
+		}
+		return args[2].eval(row)
+	}},
+	"coalesce": {1, -1, func(row map[string]string, args []node) (value, error) {
+		for _, arg := range args {
+			v, err := arg.eval(row)
+			if err != nil {
+				return v, err
+			}
+			if !v.isEmpty() {
+				return v, nil
+			}
+		}
+		return stringValue(""), nil
+	}},
+}
+
+// intArg reads an optional whole number argument
+func intArg(args []value, i int, fallback int) (int, error) {
+	if len(args) <= i {
+		return fallback, nil
+	}
+	n, err := args[i].asNumber()
+	if err != nil {
+		return fallback, err
+	}
+	if !n.IsInt() || !n.Num().IsInt64() {
+		return fallback, fmt.Errorf("%s is not a whole number", args[i].String())
+	}
+	return int(n.Num().Int64()), nil
+}
+
+// substr takes a 1 based start position and an optional length, counted in
+// characters rather than bytes
+func substr(args []value) (value, error) {
+	runes := []rune(args[0].String())
+
+	start, err := intArg(args, 1, 1)
+	if err != nil {
+		return args[1], err
+	}
+	length, err := intArg(args, 2, len(runes))
+	if err != nil {
+		return args[2], err
+	}
+
+	if start < 1 {
+		start = 1
+	}
+	if start > len(runes) || length <= 0 {
+		return stringValue(""), nil
+	}
+	end := start - 1 + length
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	return stringValue(string(runes[start-1 : end])), nil
+}
+
+// integerPart rounds towards negative infinity for a direction of -1 and
+// towards positive infinity for 1
+func integerPart(v value, direction int) (value, error) {
+	n, err := v.asNumber()
+	if err != nil {
+		return v, err
+	}
+	if n.IsInt() {
+		return numberValue(n), nil
+	}
+
+	q := new(big.Int).Quo(n.Num(), n.Denom())
+	if direction < 0 && n.Sign() < 0 {
+		q.Sub(q, big.NewInt(1))
+	}
+	if direction > 0 && n.Sign() > 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return numberValue(new(big.Rat).SetInt(q)), nil
+}
+
+// clamp keeps a number between a lower and an upper bound
+func clamp(args []value) (value, error) {
+	bounds := []*big.Rat{}
+	for _, arg := range args {
+		n, err := arg.asNumber()
+		if err != nil {
+			return arg, err
+		}
+		bounds = append(bounds, n)
+	}
+	n, low, high := bounds[0], bounds[1], bounds[2]
+	if low.Cmp(high) > 0 {
+		return args[1], fmt.Errorf("clamp lower bound %s is above the upper bound %s", args[1].String(), args[2].String())
+	}
+	if n.Cmp(low) < 0 {
+		return numberValue(low), nil
+	}
+	if n.Cmp(high) > 0 {
+		return numberValue(high), nil
+	}
+	return numberValue(n), nil
+}
+
+// extreme finds the smallest value for a direction of -1 and the largest for 1
+func extreme(args []value, direction int) (value, error) {
+	best := args[0]
+	for _, arg := range args[1:] {
+		c, err := compare(arg, best)
+		if err != nil {
+			return arg, err
+		}
+		if c*direction > 0 {
+			best = arg
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpressions(t *testing.T) {
+	row := map[string]string{
+		"FIRST":      "ada",
+		"LAST":       "Lovelace",
+		"RATE":       "25.50",
+		"HOURS":      "7.5",
+		"BLANK":      "",
+		"START":      "2020-02-01",
+		"CODE":       "ORD",
+		"with space": "spaced",
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`upper(FIRST) + " " + LAST`, "ADA Lovelace"},
+		{`RATE * HOURS`, "191.25"},
+		{`RATE + 1`, "26.5"},
+		{`concat(RATE, HOURS)`, "25.507.5"},
+		{`round(10 / 3, 2)`, "3.33"},
+		{`round(2.5)`, "3"},
+		{`round(-2.5)`, "-3"},
+		{`round(2.5, 0, "half-even")`, "2"},
+		{`round(2.345, 2, "down")`, "2.34"},
+		{`round(-2.341, 2, "floor")`, "-2.35"},
+		{`sum(RATE, HOURS, BLANK, 1)`, "34"},
+		{`sum(0.1, 0.2)`, "0.3"},
+		{`clamp(HOURS, 0, 7.6)`, "7.5"},
+		{`clamp(RATE * HOURS, 0, 100)`, "100"},
+		{`clamp(-HOURS, 0, 100)`, "0"},
+		{`convert(90, "minutes", "hours")`, "1.5"},
+		{`convert(HOURS, "hours", "minutes")`, "450"},
+		{`convert(1, "weeks", "days")`, "7"},
+		{`convert(1234, "cents", "dollars")`, "12.34"},
+		{`convert(1.5, "km", "m")`, "1500"},
+		{`10 / 4`, "2.5"},
+		{`1 / 3`, "0.3333333333"},
+		{`7 % 3`, "1"},
+		{`-HOURS`, "-7.5"},
+		{`floor(-1.5)`, "-2"},
+		{`ceil(1.2)`, "2"},
+		{`abs(-4)`, "4"},
+		{`min(3, 1, 2)`, "1"},
+		{`max(3, 10, 2)`, "10"},
+		{`substr(LAST, 1, 4)`, "Love"},
+		{`substr(LAST, 5)`, "lace"},
+		{`substr(LAST, 50)`, ""},
+		{`len(LAST)`, "8"},
+		{`replace(LAST, "love", "hate")`, "Lovelace"},
+		{`lower(LAST)`, "lovelace"},
+		{`trim("  x  ")`, "x"},
+		{`coalesce(BLANK, FIRST)`, "ada"},
+		{`coalesce(BLANK, "")`, ""},
+		{`if(CODE = "ORD", "ordinary", "other")`, "ordinary"},
+		{`if(HOURS > 10, "long", "short")`, "short"},
+		{`if(HOURS > 10 or CODE == "ORD", "yes", "no")`, "yes"},
+		{`if(not (HOURS > 10) and CODE != "OT", "yes", "no")`, "yes"},
+		{`HOURS > 10`, "false"},
+		{`"10" > "9"`, "true"},
+		{`"b" > "a"`, "true"},
+		{`parse_date("21.07.2003", "DD.MM.YYYY")`, "2003-07-21"},
+		{`format_date(parse_date("21.07.2003", "DD.MM.YYYY"), "DD/MM/YY")`, "21/07/03"},
+		{`add_days(START, 30)`, "2020-03-02"},
+		{`days_between(START, "2020-03-02")`, "30"},
+		{`date(START) < date("2020-02-02")`, "true"},
+		{"upper(`with space`)", "SPACED"},
+		{`'single' + "double"`, "singledouble"},
+		{`"escaped \" quote"`, `escaped " quote`},
+		{`LAST =~ "^Love"`, "true"},
+		{`LAST =~ "(?i)^LOVE"`, "true"},
+		{`LAST !~ "^Love"`, "false"},
+		{`CODE in ("ORD", "OT")`, "true"},
+		{`CODE not in ("ORD", "OT")`, "false"},
+		{`HOURS in (7.50, 8)`, "true"},
+		{`BLANK is empty`, "true"},
+		{`" " is empty`, "true"},
+		{`FIRST is not empty`, "true"},
+		{`not CODE in ("OT") and HOURS >= 7.5`, "true"},
+		{`date(START) >= date("2020-01-31") and RATE < 100`, "true"},
+		{`parse_date("01/03/2020", "DD/MM/YYYY") > START`, "true"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			expr, err := parseExpression(tc.expr)
+			assert.Nil(t, err)
+
+			v, err := expr.eval(row)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.want, v.String())
+		})
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	row := map[string]string{"A": "abc", "B": "0"}
+
+	parseErrors := []string{
+		`upper(`,
+		`A +`,
+		`nope(A)`,
+		`upper(A, A)`,
+		`"unterminated`,
+		`A # B`,
+		`(A`,
+		`A =~ "("`,
+		`A is full`,
+		`A in "x"`,
+	}
+	for _, input := range parseErrors {
+		_, err := parseExpression(input)
+		assert.NotNil(t, err, input)
+	}
+
+	evalErrors := []string{
+		`A * 2`,
+		`10 / B`,
+		`if(A, 1, 2)`,
+		`parse_date(A, "YYYY")`,
+		`round(1.5, -1)`,
+		`round(1.5, 0, "sideways")`,
+		`sum(1, A)`,
+		`clamp(1, 5, 2)`,
+		`clamp(A, 1, 2)`,
+		`convert(1, "hours", "km")`,
+		`convert(1, "fortnights", "days")`,
+	}
+	for _, input := range evalErrors {
+		expr, err := parseExpression(input)
+		assert.Nil(t, err, input)
+		_, err = expr.eval(row)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestParseProgram(t *testing.T) {
+	prog, err := parseProgram(`FULL = FIRST + " " + LAST; INITIAL = substr(FULL, 1, 1);FIRST = upper(FIRST)`)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"FULL", "INITIAL", "FIRST"}, prog.targets())
+	assert.Nil(t, prog.validate([]string{"FIRST", "LAST"}))
+	assert.NotNil(t, prog.validate([]string{"FIRST"}))
+
+	row := map[string]string{"FIRST": "ada", "LAST": "lovelace"}
+	prog.run(row, func(target string, err error) {
+		t.Errorf("%s: %s", target, err)
+	})
+	assert.Equal(t, "ada lovelace", row["FULL"])
+	assert.Equal(t, "a", row["INITIAL"])
+	assert.Equal(t, "ADA", row["FIRST"])
+
+	_, err = parseProgram(`FIRST + 1`)
+	assert.NotNil(t, err)
+	_, err = parseProgram(``)
+	assert.NotNil(t, err)
+}
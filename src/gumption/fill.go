@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/paidright/datalab/util"
+)
+
+// filler fills blank cells from the rows around them
+type filler struct {
+	// group names a column whose value changing stops values being carried
+	// from one block of rows to the next
+	group     string
+	lastGroup string
+	last      map[string]string
+
+	// window is how many rows below a blank cell --fill-up will look
+	window int
+	buffer []util.Line
+}
+
+func newFiller(op *operation, headers []string) (*filler, error) {
+	f := filler{
+		group: op.option("group", ""),
+		last:  map[string]string{},
+	}
+
+	if f.group != "" && !util.Contains(f.group, headers) {
+		return nil, fmt.Errorf("unknown group column %s", f.group)
+	}
+
+	if op.name == "fillUp" {
+		window, err := strconv.Atoi(op.flag.value)
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("fill up expects the number of rows to look ahead eg: 5")
+		}
+		f.window = window
+	}
+
+	return &f, nil
+}
+
+func isBlank(cell string) bool {
+	return strings.TrimSpace(cell) == ""
+}
+
+// down fills blank cells with the last value seen above them
+func (f *filler) down(op *operation, line util.Line) {
+	if f.group != "" && line.Data[f.group] != f.lastGroup {
+		f.last = map[string]string{}
+		f.lastGroup = line.Data[f.group]
+	}
+
+	for _, col := range op.columns {
+		if !isBlank(line.Data[col]) {
+			f.last[col] = line.Data[col]
+			continue
+		}
+		if value, ok := f.last[col]; ok {
+			line.Data[col] = value
+			op.count("filled")
+		}
+	}
+}
+
+// up fills blank cells with the next value found no more than window rows
+// below them. Rows are held back until they can't be filled any more, so up
+// returns the rows that are ready to carry on.
+func (f *filler) up(op *operation, line util.Line) []util.Line {
+	ready := []util.Line{}
+
+	if f.group != "" && len(f.buffer) > 0 && f.buffer[0].Data[f.group] != line.Data[f.group] {
+		ready = f.buffer
+		f.buffer = []util.Line{}
+	}
+
+	for _, col := range op.columns {
+		if isBlank(line.Data[col]) {
+			continue
+		}
+		for i := len(f.buffer) - 1; i >= 0 && len(f.buffer)-i <= f.window; i-- {
+			if !isBlank(f.buffer[i].Data[col]) {
+				break
+			}
+			f.buffer[i].Data[col] = line.Data[col]
+			op.count("filled")
+		}
+	}
+
+	f.buffer = append(f.buffer, line)
+
+	for len(f.buffer) > 0 && (len(f.buffer) > f.window || !f.waiting(op, f.buffer[0])) {
+		ready = append(ready, f.buffer[0])
+		f.buffer = f.buffer[1:]
+	}
+
+	return ready
+}
+
+// waiting reports whether a line still has blanks that a later row could fill
+func (f *filler) waiting(op *operation, line util.Line) bool {
+	for _, col := range op.columns {
+		if isBlank(line.Data[col]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *filler) flush() []util.Line {
+	ready := f.buffer
+	f.buffer = []util.Line{}
+	return ready
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFillDown(t *testing.T) {
+	input := `ID,NAME,DEPT
+1,alice,ops
+2,,
+3,bob,
+4,,it`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	flags := map[string]flagval{
+		"fillDown": flagval{active: true},
+	}
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"NAME", "DEPT"}, flags))
+	writer.Flush()
+
+	assert.Equal(t, `ID,NAME,DEPT
+1,alice,ops
+2,alice,ops
+3,bob,ops
+4,bob,it
+`, result.String())
+}
+
+func TestFillDownGroup(t *testing.T) {
+	input := `EMP,RATE
+1,25
+1,
+2,
+2,30
+2,`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	flags := map[string]flagval{
+		"fillDown": flagval{active: true, options: map[string]string{"group": "EMP"}},
+	}
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"RATE"}, flags))
+	writer.Flush()
+
+	assert.Equal(t, `EMP,RATE
+1,25
+1,25
+2,
+2,30
+2,30
+`, result.String())
+}
+
+func TestFillUp(t *testing.T) {
+	input := `ID,TOTAL
+1,
+2,
+3,
+4,10
+5,
+6,20
+7,`
+
+	for _, tc := range []struct {
+		window   string
+		expected string
+	}{
+		{"1", "ID,TOTAL\n1,\n2,\n3,10\n4,10\n5,20\n6,20\n7,\n"},
+		{"2", "ID,TOTAL\n1,\n2,10\n3,10\n4,10\n5,20\n6,20\n7,\n"},
+		{"5", "ID,TOTAL\n1,10\n2,10\n3,10\n4,10\n5,20\n6,20\n7,\n"},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		flags := map[string]flagval{
+			"fillUp": flagval{active: true, value: tc.window},
+		}
+		assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"TOTAL"}, flags))
+		writer.Flush()
+
+		assert.Equal(t, tc.expected, result.String(), tc.window)
+	}
+}
+
+func TestFillUpGroupThroughLaterSteps(t *testing.T) {
+	recipe := `steps:
+  - op: fill-up
+    columns: [CODE]
+    value: "10"
+    options:
+      group: EMP
+  - op: where
+    value: CODE != "X"
+  - op: eval
+    value: LABEL = EMP + "-" + CODE`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	input := `EMP,CODE
+1,
+1,A
+2,
+2,
+3,X
+3,
+3,B`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader(input), *writer, operations, true, "", ""))
+	writer.Flush()
+
+	assert.Equal(t, `EMP,CODE,LABEL
+1,A,1-A
+1,A,1-A
+2,,2-
+2,,2-
+3,B,3-B
+3,B,3-B
+`, result.String())
+}
+
+func TestFillErrors(t *testing.T) {
+	for _, flags := range []map[string]flagval{
+		{"fillUp": flagval{active: true, value: "0"}},
+		{"fillUp": flagval{active: true, value: "lots"}},
+		{"fillDown": flagval{active: true, options: map[string]string{"group": "NOPE"}}},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.NotNil(t, gumption(strings.NewReader("one,two\n1,2"), *writer, []string{}, flags))
+	}
+}
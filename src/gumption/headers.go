@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/paidright/datalab/util"
+)
+
+var headerCases = []string{"snake", "camel", "upper"}
+
+// parseRenameMap reads old:new pairs, either inline eg: "Emp No:EMP_ID,Dept:DEPT"
+// or from a CSV file with the old names in the first column and the new names
+// in the second
+func parseRenameMap(value string) (map[string]string, error) {
+	renames := map[string]string{}
+
+	add := func(from string, to string) error {
+		if _, ok := renames[from]; ok {
+			return fmt.Errorf("%s is renamed more than once", from)
+		}
+		if strings.TrimSpace(to) == "" {
+			return fmt.Errorf("no new name for %s", from)
+		}
+		renames[from] = to
+		return nil
+	}
+
+	if _, err := os.Stat(value); err == nil {
+		err := util.ReadFile(value, func(line map[string]string, headers []string, lineNumber int) error {
+			if len(headers) < 2 {
+				return fmt.Errorf("%s needs a column of old names and a column of new names", value)
+			}
+			return add(line[headers[0]], line[headers[1]])
+		})
+		return renames, err
+	}
+
+	if !strings.Contains(value, ":") {
+		return renames, fmt.Errorf("rename map %s is neither a file nor a list of old:new pairs", value)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return renames, fmt.Errorf("expected old:new but got %s", pair)
+		}
+		if err := add(parts[0], parts[1]); err != nil {
+			return renames, err
+		}
+	}
+	return renames, nil
+}
+
+// headerWords splits a header into words at spaces and punctuation, and where
+// the case changes, so "Employee ID", "employee_id" and "employeeID" all
+// become employee and ID
+func headerWords(header string) []string {
+	words := []string{}
+	word := []rune{}
+	runes := []rune(header)
+
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = []rune{}
+			}
+			continue
+		}
+		if len(word) > 0 && unicode.IsUpper(r) {
+			previous := word[len(word)-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				words = append(words, string(word))
+				word = []rune{}
+			}
+		}
+		word = append(word, r)
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+// convertCase writes a header in one of the headerCases
+func convertCase(header string, convention string) string {
+	words := headerWords(header)
+	switch convention {
+	case "camel":
+		for i, word := range words {
+			word = strings.ToLower(word)
+			if i > 0 {
+				runes := []rune(word)
+				runes[0] = unicode.ToUpper(runes[0])
+				word = string(runes)
+			}
+			words[i] = word
+		}
+		return strings.Join(words, "")
+	case "upper":
+		return strings.ToUpper(strings.Join(words, "_"))
+	}
+	return strings.ToLower(strings.Join(words, "_"))
+}
+
+// stripNonASCII drops every character outside of printable ASCII
+func stripNonASCII(header string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, header)
+}
+
+func truncateHeader(header string, length int) string {
+	runes := []rune(header)
+	if len(runes) > length {
+		return string(runes[:length])
+	}
+	return header
+}
+
+// uniqueHeader makes sure a new header doesn't clash with one already taken
+// by adding a numbered suffix, shortening the name to keep it within length
+// if there is one. It fails if length leaves no room for the suffix.
+func uniqueHeader(header string, taken []string, length int) (string, error) {
+	if header == "" {
+		header = "column"
+	}
+	if length > 0 {
+		header = truncateHeader(header, length)
+	}
+	if !util.Contains(header, taken) {
+		return header, nil
+	}
+	for i := 1; ; i++ {
+		suffix := "_" + strconv.Itoa(i)
+		base := header
+		if length > 0 {
+			if len(suffix) >= length {
+				return header, fmt.Errorf("cannot tell apart columns named %s in %d characters", header, length)
+			}
+			base = truncateHeader(header, length-len(suffix))
+		}
+		candidate := base + suffix
+		if !util.Contains(candidate, taken) {
+			return candidate, nil
+		}
+	}
+}
+
+// renameHeaders renames the target headers, recording each new name in the
+// operation's outputs
+func (op *operation) renameHeaders(headers []string, targets []string, rename func(string) string, length int) ([]string, error) {
+	renamed := append([]string{}, headers...)
+
+	taken := []string{}
+	for _, header := range headers {
+		if !util.Contains(header, targets) {
+			taken = append(taken, header)
+		}
+	}
+
+	for i, header := range headers {
+		if !util.Contains(header, targets) {
+			continue
+		}
+		name, err := uniqueHeader(rename(header), taken, length)
+		if err != nil {
+			return headers, err
+		}
+		taken = append(taken, name)
+		renamed[i] = name
+		if name != header {
+			op.outputs[header] = name
+		}
+	}
+
+	return renamed, nil
+}
+
+// rekey moves the cells of renamed columns to their new names. The cells are
+// moved all at once so that columns can swap names.
+func (op *operation) rekey(line util.Line) {
+	data := map[string]string{}
+	for col, cell := range line.Data {
+		if _, ok := op.outputs[col]; !ok {
+			data[col] = cell
+		}
+	}
+	for col, name := range op.outputs {
+		data[name] = line.Data[col]
+	}
+	for col := range line.Data {
+		delete(line.Data, col)
+	}
+	for col, cell := range data {
+		line.Data[col] = cell
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertCase(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		snake  string
+		camel  string
+		upper  string
+	}{
+		{"Employee ID", "employee_id", "employeeId", "EMPLOYEE_ID"},
+		{"employeeID", "employee_id", "employeeId", "EMPLOYEE_ID"},
+		{"HTTPServer", "http_server", "httpServer", "HTTP_SERVER"},
+		{"  start.date-time ", "start_date_time", "startDateTime", "START_DATE_TIME"},
+		{"Address2", "address2", "address2", "ADDRESS2"},
+		{"PAY_CODE", "pay_code", "payCode", "PAY_CODE"},
+	} {
+		assert.Equal(t, tc.snake, convertCase(tc.header, "snake"), tc.header)
+		assert.Equal(t, tc.camel, convertCase(tc.header, "camel"), tc.header)
+		assert.Equal(t, tc.upper, convertCase(tc.header, "upper"), tc.header)
+	}
+}
+
+func TestUniqueHeader(t *testing.T) {
+	taken := []string{"employee", "employee_1", "employ_1", "employ_2"}
+
+	for _, tc := range []struct {
+		header string
+		length int
+		want   string
+	}{
+		{"rate", 0, "rate"},
+		{"employee", 0, "employee_2"},
+		{"employee_number", 8, "employ_3"},
+		{"", 0, "column"},
+	} {
+		header, err := uniqueHeader(tc.header, taken, tc.length)
+		assert.Nil(t, err, tc.header)
+		assert.Equal(t, tc.want, header, tc.header)
+	}
+}
+
+func TestHeaderMaxLengthCollisions(t *testing.T) {
+	input := "abcx,abcy,abcz\n1,2,3"
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	flags := map[string]flagval{"headerMaxLength": flagval{active: true, value: "3"}}
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{}, flags))
+	writer.Flush()
+
+	assert.Equal(t, "abc,a_1,a_2\n1,2,3\n", result.String())
+
+	// Two characters leave no room for a numbered suffix
+	flags = map[string]flagval{"headerMaxLength": flagval{active: true, value: "2"}}
+	err := gumption(strings.NewReader(input), *csv.NewWriter(&strings.Builder{}), []string{}, flags)
+	assert.NotNil(t, err)
+}
+
+func TestRenameMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mapPath := path.Join(dir, "headers.csv")
+	assert.Nil(t, ioutil.WriteFile(mapPath, []byte("old,new\nEmp No,EMP_ID\nA,B\nB,A\n"), 0644))
+
+	input := `Emp No,A,B,Rate
+1,a,b,25`
+
+	for _, value := range []string{mapPath, "Emp No:EMP_ID,A:B,B:A"} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		flags := map[string]flagval{
+			"renameMap": flagval{active: true, value: value},
+		}
+		assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{}, flags))
+		writer.Flush()
+
+		assert.Equal(t, `EMP_ID,B,A,Rate
+1,a,b,25
+`, result.String(), value)
+	}
+}
+
+func TestHeaderConventions(t *testing.T) {
+	recipe := `steps:
+  - op: header-ascii
+  - op: header-case
+    value: snake
+  - op: header-max-length
+    value: "10"
+  - op: eval
+    value: total = pay_rate_p * 2`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	input := `Employée Name,employee_name,Pay Rate Per Hour,Pay Rate Per Day
+Zoë,zoe,25,200`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader(input), *writer, operations, true, "", ""))
+	writer.Flush()
+
+	assert.Equal(t, `employe_na,employee_n,pay_rate_p,pay_rate_1,total
+Zoë,zoe,25,200,50
+`, result.String())
+}
+
+func TestRenameMapErrors(t *testing.T) {
+	for _, flags := range []map[string]flagval{
+		{"renameMap": flagval{active: true, value: "no-such-file.csv"}},
+		{"renameMap": flagval{active: true, value: "one:uno,one:eins"}},
+		{"renameMap": flagval{active: true, value: "one:"}},
+		{"headerCase": flagval{active: true, value: "kebab"}},
+		{"headerMaxLength": flagval{active: true, value: "-1"}},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.NotNil(t, gumption(strings.NewReader("one,two\n1,2"), *writer, []string{}, flags))
+	}
+}
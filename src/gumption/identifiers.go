@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/paidright/datalab/util"
+)
+
+// identifier is a kind of Australian number that can be checked and written
+// out in a standard way
+type identifier struct {
+	name  string
+	valid func(digits string) bool
+	// format writes out the digits of a valid identifier
+	format func(digits string) string
+}
+
+func plainDigits(digits string) string {
+	return digits
+}
+
+// weightedSum multiplies each digit by its weight and adds them up
+func weightedSum(digits string, weights []int) int {
+	sum := 0
+	for i, r := range digits {
+		sum += int(r-'0') * weights[i]
+	}
+	return sum
+}
+
+// validTFN checks a tax file number against the ATO's check digit algorithm.
+// Older TFNs have 8 digits, newer ones 9.
+func validTFN(digits string) bool {
+	switch len(digits) {
+	case 8:
+		return weightedSum(digits, []int{10, 7, 8, 4, 6, 3, 5, 1})%11 == 0
+	case 9:
+		return weightedSum(digits, []int{1, 4, 3, 7, 5, 8, 6, 9, 10})%11 == 0
+	}
+	return false
+}
+
+// validABN checks an Australian business number. One is taken off the first
+// digit before the weighted sum, which has to divide by 89.
+func validABN(digits string) bool {
+	if len(digits) != 11 || digits[0] == '0' {
+		return false
+	}
+	adjusted := string(digits[0]-1) + digits[1:]
+	return weightedSum(adjusted, []int{10, 1, 3, 5, 7, 9, 11, 13, 15, 17, 19})%89 == 0
+}
+
+var identifiers = map[string]identifier{
+	"tfn": identifier{
+		name:   "tfn",
+		valid:  validTFN,
+		format: plainDigits,
+	},
+	"abn": identifier{
+		name:   "abn",
+		valid:  validABN,
+		format: plainDigits,
+	},
+	"bsb": identifier{
+		name: "bsb",
+		valid: func(digits string) bool {
+			return len(digits) == 6
+		},
+		format: func(digits string) string {
+			return digits[:3] + "-" + digits[3:]
+		},
+	},
+	// Account numbers have no check digit that works across banks, so all
+	// that can be checked is the length
+	"bankAccount": identifier{
+		name: "bank account",
+		valid: func(digits string) bool {
+			return len(digits) >= 6 && len(digits) <= 10
+		},
+		format: plainDigits,
+	},
+}
+
+// identifierCheck validates and tidies up one kind of identifier
+type identifierCheck struct {
+	identifier
+	onInvalid string
+}
+
+func newIdentifierCheck(op *operation) (*identifierCheck, error) {
+	c := identifierCheck{
+		identifier: identifiers[op.name],
+		onInvalid:  op.option("on-invalid", "keep"),
+	}
+	if !util.Contains(c.onInvalid, invalidModes) {
+		return nil, fmt.Errorf("on-invalid must be one of %s", strings.Join(invalidModes, ", "))
+	}
+	return &c, nil
+}
+
+// stripFormatting removes the spaces and dashes people write identifiers
+// with, reporting whether only digits were left
+func stripFormatting(cell string) (string, bool) {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' {
+			return -1
+		}
+		return r
+	}, cell)
+	return digits, isDigits(digits)
+}
+
+// check returns the tidied up identifier and whether it was valid
+func (c *identifierCheck) check(cell string) (string, bool) {
+	digits, ok := stripFormatting(cell)
+	if !ok || !c.valid(digits) {
+		return cell, false
+	}
+	return c.format(digits), true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentifierChecks(t *testing.T) {
+	for _, tc := range []struct {
+		kind     string
+		cell     string
+		expected string
+		valid    bool
+	}{
+		{"tfn", "123 456 782", "123456782", true},
+		{"tfn", "123-456-782", "123456782", true},
+		{"tfn", "12345677", "12345677", true},
+		{"tfn", "123456789", "123456789", false},
+		{"tfn", "12345678A", "12345678A", false},
+		{"tfn", "1234567", "1234567", false},
+		{"abn", "51 824 753 556", "51824753556", true},
+		{"abn", "53004085616", "53004085616", true},
+		{"abn", "51 824 753 557", "51 824 753 557", false},
+		{"abn", "01824753556", "01824753556", false},
+		{"bsb", "062000", "062-000", true},
+		{"bsb", "062 000", "062-000", true},
+		{"bsb", "062-000", "062-000", true},
+		{"bsb", "62000", "62000", false},
+		{"bankAccount", "1234 5678", "12345678", true},
+		{"bankAccount", "00123456", "00123456", true},
+		{"bankAccount", "12345", "12345", false},
+		{"bankAccount", "12345678901", "12345678901", false},
+	} {
+		c := identifierCheck{identifier: identifiers[tc.kind]}
+		checked, valid := c.check(tc.cell)
+		assert.Equal(t, tc.expected, checked, tc.kind+" "+tc.cell)
+		assert.Equal(t, tc.valid, valid, tc.kind+" "+tc.cell)
+	}
+}
+
+const identifierInput = `ID,TFN,BSB
+1,123 456 782,062 000
+2,123456789,062000
+3,,6200`
+
+func TestIdentifierFlag(t *testing.T) {
+	recipe := `steps:
+  - op: tfn
+    columns: [TFN]
+    options:
+      on-invalid: flag
+  - op: bsb
+    columns: [BSB]
+    options:
+      on-invalid: blank`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader(identifierInput), *writer, operations, true, "", ""))
+	writer.Flush()
+
+	assert.Equal(t, `ID,TFN,BSB,TFN_valid
+1,123456782,062-000,true
+2,123456789,062-000,false
+3,,,
+`, result.String())
+}
+
+func TestIdentifierReject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rejectsPath := path.Join(dir, "rejects.csv")
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	flags := map[string]flagval{
+		"tfn": flagval{
+			active:  true,
+			options: map[string]string{"on-invalid": "reject"},
+		},
+		"rejects": flagval{
+			active: true,
+			value:  rejectsPath,
+		},
+	}
+	assert.Nil(t, gumption(strings.NewReader(identifierInput), *writer, []string{"TFN"}, flags))
+	writer.Flush()
+
+	assert.Equal(t, `ID,TFN,BSB
+1,123456782,062 000
+3,,6200
+`, result.String())
+
+	rejected, err := ioutil.ReadFile(rejectsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, `ID,TFN,BSB,gumption_reject_reason
+2,123456789,062000,invalid tfn
+`, string(rejected))
+}
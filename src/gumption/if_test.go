@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfFlag(t *testing.T) {
+	input := `ID,SOURCE,PAYCODE,HOURS
+0012,legacy,ORD,
+0013,new,ORD,
+0014,legacy,OT,`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	flags := map[string]flagval{
+		"stripLeadingZeroes": flagval{active: true},
+		"addMissing":         flagval{active: true, value: "0"},
+		"if":                 flagval{active: true, value: `SOURCE = "legacy"`},
+	}
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"ID", "HOURS"}, flags))
+	writer.Flush()
+
+	assert.Equal(t, `ID,SOURCE,PAYCODE,HOURS
+12,legacy,ORD,0
+0013,new,ORD,
+14,legacy,OT,0
+`, result.String())
+}
+
+func TestIfRecipe(t *testing.T) {
+	recipe := `steps:
+  - op: add-missing
+    columns: [HOURS]
+    value: "7.6"
+    if: PAYCODE = "ORD"
+  - op: rename
+    columns: [HOURS]
+    value: UNITS
+  - op: where
+    value: UNITS is not empty
+    if: PAYCODE in ("ORD", "OT")
+  - op: eval
+    value: PAY = UNITS * 20
+    if: UNITS is not empty`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	input := `ID,PAYCODE,HOURS
+1,ORD,
+2,OT,
+3,LEAVE,
+4,OT,2`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader(input), *writer, operations, true, "", ""))
+	writer.Flush()
+
+	assert.Equal(t, `ID,PAYCODE,UNITS,PAY
+1,ORD,7.6,152
+3,LEAVE,,
+4,OT,2,40
+`, result.String())
+}
+
+func TestIfErrors(t *testing.T) {
+	for _, recipe := range []string{
+		"steps:\n  - op: rename\n    columns: [one]\n    value: uno\n    if: two = 1",
+		"steps:\n  - op: fill-up\n    value: \"2\"\n    if: two = 1",
+	} {
+		_, err := loadRecipe(strings.NewReader(recipe))
+		assert.True(t, errors.Is(err, errUnconditional), recipe)
+	}
+
+	// Given as flags, --if fails the same way rather than dropping the column
+	// for every row
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+	flags := map[string]flagval{
+		"drop": flagval{active: true},
+		"if":   flagval{active: true, value: `two = 1`},
+	}
+	err := gumption(strings.NewReader("one,two\n1,2"), *writer, []string{"one"}, flags)
+	assert.True(t, errors.Is(err, errUnconditional))
+	assert.Equal(t, "", result.String())
+
+	for _, condition := range []string{`three = 1`, `two = `} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		flags := map[string]flagval{
+			"addMissing": flagval{active: true, value: "0"},
+			"if":         flagval{active: true, value: condition},
+		}
+		assert.NotNil(t, gumption(strings.NewReader("one,two\n1,2"), *writer, []string{}, flags), condition)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/paidright/datalab/util"
+)
+
+// keySeparator goes between the key values a surrogate ID is made from, so
+// that "ab","c" and "a","bc" get different IDs
+const keySeparator = "\x1f"
+
+// sequence numbers rows from 1, starting again for each value of group
+type sequence struct {
+	target string
+	group  string
+	next   map[string]int
+}
+
+func newSequence(op *operation, headers []string) (*sequence, error) {
+	s := sequence{
+		target: op.flag.value,
+		group:  op.option("group", ""),
+		next:   map[string]int{},
+	}
+	if s.group != "" && !util.Contains(s.group, headers) {
+		return nil, fmt.Errorf("unknown group column %s", s.group)
+	}
+	return &s, nil
+}
+
+func (s *sequence) number(line util.Line) string {
+	group := ""
+	if s.group != "" {
+		group = line.Data[s.group]
+	}
+	s.next[group]++
+	return strconv.Itoa(s.next[group])
+}
+
+func parseUUID(value string) ([]byte, error) {
+	raw, err := hex.DecodeString(strings.ReplaceAll(value, "-", ""))
+	if err != nil || len(raw) != 16 {
+		return nil, fmt.Errorf("namespace must be a UUID eg: 6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	}
+	return raw, nil
+}
+
+// uuidV5 makes a name based UUID as described in RFC 4122. The same namespace
+// and name always give the same UUID.
+func uuidV5(namespace []byte, name string) string {
+	h := sha1.New()
+	h.Write(namespace)
+	h.Write([]byte(name))
+	sum := h.Sum(nil)[:16]
+
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+
+	id := hex.EncodeToString(sum)
+	return id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:]
+}
+
+// surrogateID makes an ID for a row from the values of its key columns
+func surrogateID(namespace []byte, keys []string, line util.Line) string {
+	values := []string{}
+	for _, key := range keys {
+		values = append(values, line.Data[key])
+	}
+	return uuidV5(namespace, strings.Join(values, keySeparator))
+}
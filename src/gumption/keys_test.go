@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUUIDv5(t *testing.T) {
+	dns, err := parseUUID("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	assert.Nil(t, err)
+
+	assert.Equal(t, "886313e1-3b8a-5372-9b90-0c9aee199e5d", uuidV5(dns, "python.org"))
+
+	for _, bad := range []string{"", "not-a-uuid", "6ba7b810-9dad-11d1-80b4"} {
+		_, err := parseUUID(bad)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	input := `EMP,DATE
+1,2020-01-01
+1,2020-01-02
+2,2020-01-01
+1,2020-01-03`
+
+	for _, tc := range []struct {
+		group    string
+		expected string
+	}{
+		{"", "EMP,DATE,ROW\n1,2020-01-01,1\n1,2020-01-02,2\n2,2020-01-01,3\n1,2020-01-03,4\n"},
+		{"EMP", "EMP,DATE,ROW\n1,2020-01-01,1\n1,2020-01-02,2\n2,2020-01-01,1\n1,2020-01-03,3\n"},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		flags := map[string]flagval{
+			"sequence": flagval{
+				active:  true,
+				value:   "ROW",
+				options: map[string]string{"group": tc.group},
+			},
+		}
+		assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{}, flags))
+		writer.Flush()
+
+		assert.Equal(t, tc.expected, result.String(), tc.group)
+	}
+}
+
+func TestSurrogateID(t *testing.T) {
+	recipe := `steps:
+  - op: where
+    value: HOURS > 0
+  - op: surrogate-id
+    columns: [EMP, DATE]
+    value: SHIFT_ID
+    options:
+      namespace: 1b671a64-40d5-491e-99b0-da01ff1f3341
+  - op: sequence
+    value: LINE
+    options:
+      group: EMP`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	// The IDs depend only on the key columns, so they come out the same
+	// however the file is ordered or filtered
+	for _, input := range []string{
+		"EMP,DATE,HOURS\n1,2020-01-01,7.5\n2,2020-01-01,8\n",
+		"EMP,DATE,HOURS\n3,2020-01-01,0\n2,2020-01-01,6\n1,2020-01-01,7.5\n",
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.Nil(t, runOperations(strings.NewReader(input), *writer, operations, true, "", ""))
+		writer.Flush()
+
+		assert.Contains(t, result.String(), "1,2020-01-01,7.5,cd4e87c9-b62c-53c6-9cde-ec13e020980b,1\n")
+		assert.Contains(t, result.String(), ",0c08862a-4640-5db1-80f4-45ada2d31818,1\n")
+	}
+}
+
+func TestSurrogateIDRowOrder(t *testing.T) {
+	flags := map[string]flagval{
+		"sequence": flagval{active: true, value: "SEQ"},
+		"surrogateId": flagval{
+			active:  true,
+			value:   "UID",
+			options: map[string]string{"namespace": "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		},
+	}
+
+	ids := []map[string]string{}
+	for _, input := range []string{"EMP,NAME\n1,a\n2,b\n", "EMP,NAME\n2,b\n1,a\n"} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"EMP", "NAME"}, flags))
+		writer.Flush()
+
+		lines := strings.Split(strings.TrimSpace(result.String()), "\n")
+		assert.Equal(t, "EMP,NAME,SEQ,UID", lines[0])
+		found := map[string]string{}
+		for _, line := range lines[1:] {
+			fields := strings.Split(line, ",")
+			found[fields[0]] = fields[3]
+		}
+		ids = append(ids, found)
+	}
+
+	assert.Equal(t, ids[0], ids[1])
+}
+
+func TestKeyErrors(t *testing.T) {
+	for _, flags := range []map[string]flagval{
+		{"sequence": flagval{active: true, value: "ROW", options: map[string]string{"group": "NOPE"}}},
+		{"surrogateId": flagval{active: true, value: "ID"}},
+		{"surrogateId": flagval{active: true, value: "ID", options: map[string]string{"namespace": "nope"}}},
+		{"surrogateId": flagval{active: true, value: "ID", options: map[string]string{"namespace": "1b671a64-40d5-491e-99b0-da01ff1f3341"}}},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.NotNil(t, gumption(strings.NewReader("one,two\n1,2"), *writer, []string{}, flags))
+	}
+}
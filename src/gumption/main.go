@@ -5,12 +5,9 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/paidright/datalab/util"
 )
@@ -23,22 +20,107 @@ var stripLeadingZeroes = flag.Bool("strip-leading-zeroes", false, "Strip leading
 var leftPad = flag.String("left-pad", "", "Left pad the column with a character to the specified width")
 var unquote = flag.Bool("unquote", false, "Strip quotation marks from strings")
 var commasToPoints = flag.Bool("commas-to-points", false, "Replace all commas with full stops")
+var fillDown = flag.Bool("fill-down", false, "Fill blank cells with the last value above them")
+var fillUp = flag.Int("fill-up", 0, "Fill blank cells with the next value below them, looking no more than this many rows ahead")
+var fillGroup = flag.String("fill-group", "", "Column whose value changing stops --fill-down and --fill-up carrying values across")
 var addMissing = flag.String("add-missing", "", "String with which to replace blank fields")
 var replaceCell = flag.String("replace-cell", "", "Take any cells that match X and replace it with Y eg: X,Y. You may specify multiple tuples, ie: A,B,X,Y")
 var replaceCellLookup = flag.String("replace-cell-lookup", "", "Take any cells that match X and replace it with the value found in column Y eg: X,Y. You may specify multiple tuples, ie: A,B,X,Y")
 var replaceChar = flag.String("replace-char", "", "Look through all the cells in the target columns and replace any occurrences of the character X with the character Y")
+var mapFile = flag.String("map-file", "", "CSV file of values to swap cells for. Use with --map-from and --map-to")
+var mapFrom = flag.String("map-from", "", "Column in --map-file holding the values to look for")
+var mapTo = flag.String("map-to", "", "Column in --map-file holding the values to replace them with")
+var mapDefault = flag.String("map-default", "", "Value for cells --map-file has no mapping for. Leave unset to keep them as they are")
+var mapIgnoreCase = flag.Bool("map-ignore-case", false, "Ignore case when looking up values in --map-file")
+var mapUnmapped = flag.String("map-unmapped", "", "Write every value --map-file had no mapping for, with how often it was seen, to this file")
+var regexExtract = flag.String("regex-extract", "", "Copy the first capture group of PATTERN into a new column eg: 'EMP(\\d+)=>EMPLOYEE_ID'")
 var rename = flag.String("rename", "", "New name to assign to the column(s)")
 var splitOnDelim = flag.String("split", "", "Delimiter on which to split the column(s)")
+var splitInto = flag.String("split-into", "", "Split the column on --delimiter into the named columns eg: YEAR,MONTH,DAY. The last column gets whatever is left over")
+var explodeCol = flag.Bool("explode", false, "Turn a column holding a --delimiter separated list into one row per element, copying the other columns")
+var delimiter = flag.String("delimiter", defaultDelimiter, "Delimiter for --split-into and --explode")
+var splitPad = flag.String("split-pad", "", "Value --split-into gives columns that a short cell doesn't reach")
+var explodeIndex = flag.String("explode-index", "", "Column --explode numbers the elements in. Defaults to <column>_index")
 var cp = flag.Bool("copy", false, "Whether to copy the column(s)")
 var drop = flag.Bool("drop", false, "Whether to drop the column(s)")
 var stompAlphas = flag.Bool("stomp-alphas", false, "Remove all alpha (A-Z,a-z) characters")
 var deleteWhere = flag.String("delete-where", "", "In any row where a cell matches X delete the row")
 var deleteWhereNot = flag.String("delete-where-not", "", "In any row where a cell does not match X delete the row")
 var trimWhitespace = flag.Bool("trim-whitespace", false, "Trim leading and trailing whitespace from cells in the target columns")
+var removeNonPrintableCols = flag.Bool("remove-non-printable", false, "Remove control and formatting characters such as zero width spaces from cells in the target columns")
+var normalizeUnicode = flag.String("normalize-unicode", "", "Normalise cells to a unicode normalisation form. One of NFC, NFD, NFKC or NFKD")
+var stripAccentsCols = flag.Bool("strip-accents", false, "Take accents and other marks off letters eg: é becomes e")
+var straightenQuotesCols = flag.Bool("straighten-quotes", false, "Replace curly quotes with straight ones")
+var collapseWhitespaceCols = flag.Bool("collapse-whitespace", false, "Replace each run of whitespace, including non-breaking spaces and line breaks, with a single space and trim the ends")
+var textCase = flag.String("case", "", "Change the case of cells. One of "+strings.Join(textCases, ", "))
+var truncateCols = flag.Int("truncate", -1, "Cut cells down to at most this many characters")
 var backToFront = flag.String("back-to-front", "", "If there is a trailing character that matches the value, move it to the front")
-var reformatDate = flag.String("reformat-date", "", "Parse dates according to the input format and spit them into the output format. Ignore malformed dates.")
+var reformatDate = flag.String("reformat-date", "", "Parse dates according to the input format and spit them into the output format eg: 'DD/MM/YYYY|YYYY-MM-DD,YYYY-MM-DD'. Separate several input formats with |")
+var dateAmbiguity = flag.String("date-ambiguity", "first", "What to do when input formats read a date differently, eg: 01/02/2020. One of first, dmy, mdy or reject")
+var onInvalid = flag.String("on-invalid", "keep", "What to do with cells --reformat-date, --normalize-number, --tfn, --abn, --bsb, --bank-account or --eval can't read. One of keep, blank, flag (add a <column>_valid column), reject or fail (finish the run then exit with an error)")
+var normalizeNumber = flag.String("normalize-number", "", "Read numbers written for the given locale eg: en-AU, de or fr and write them as plain decimals. Understands currency, (123.45) and trailing minus negatives and percentages")
+var numberPrecision = flag.Int("number-precision", -1, "Number of decimal places --normalize-number writes. Leave unset to keep as many as are needed")
+var rounding = flag.String("rounding", string(util.RoundHalfEven), "How --normalize-number rounds to --number-precision. One of "+strings.Join(util.RoundingModes, ", "))
+var tfnCols = flag.Bool("tfn", false, "Check tax file numbers against their check digit and strip spaces and dashes from them")
+var abnCols = flag.Bool("abn", false, "Check Australian business numbers against their check digits and strip spaces and dashes from them")
+var bsbCols = flag.Bool("bsb", false, "Check BSBs have six digits and write them as NNN-NNN")
+var bankAccountCols = flag.Bool("bank-account", false, "Check bank account numbers have 6 to 10 digits and strip spaces and dashes from them")
 var reformatTime = flag.String("reformat-time", "", "Parse times according to the input format and spit them into the output format. Ignore malformed times.")
+var convertTz = flag.String("convert-tz", "", "Convert wall clock times from one IANA timezone to another eg: Australia/Perth,Australia/Sydney. Use @COLUMN to read the source timezone from a column")
+var toUtc = flag.String("to-utc", "", "Convert wall clock times in the given IANA timezone, or @COLUMN, to UTC")
+var toEpoch = flag.String("to-epoch", "", "Convert wall clock times in the given IANA timezone, or @COLUMN, to seconds since the unix epoch")
+var tzLayout = flag.String("tz-layout", defaultTzLayout, "Layout of the times read and written by --convert-tz, --to-utc and --to-epoch. Give an input and an output layout separated by a comma to change the layout as well")
+var dstOverlap = flag.String("dst-overlap", "earlier", "What to do with times that happen twice when daylight saving ends. One of earlier, later or reject")
+var dstGap = flag.String("dst-gap", "shift", "What to do with times that never happen when daylight saving starts. One of shift (forward by the length of the gap) or reject")
+var duration = flag.String("duration", "", "Work out the time between two columns into a new column eg: START_DATE_TIME,END_DATE_TIME,HOURS")
+var durationUnit = flag.String("duration-unit", "decimal-hours", "Unit for --duration. One of decimal-hours (7.50), hours (7:30) or minutes (450)")
+var durationLayout = flag.String("duration-layout", defaultDurationLayout, "Layout of the times read by --duration. Separate several layouts with |. Layouts with only a time are assumed to cross midnight when the end is before the start")
+var durationPrecision = flag.Int("duration-precision", 2, "Number of decimal places --duration writes for decimal-hours")
+var breakColumn = flag.String("break-column", "", "Column holding minutes of unpaid break for --duration to subtract")
+var tokeniseCols = flag.Bool("tokenise", false, "Replace cells with a keyed HMAC-SHA256 token. The same value always gets the same token. The key is read from --key-file or the GUMPTION_HMAC_KEY environment variable")
+var keyFile = flag.String("key-file", "", "File holding the key for --tokenise")
+var maskCols = flag.String("mask", "", "Replace all but the last N characters with * eg: 4 turns 123456789 into *****6789")
+var redactCols = flag.Bool("redact", false, "Replace letters with X and digits with 9, keeping punctuation and spacing")
+var nullCols = flag.Bool("null", false, "Empty every cell in the target columns")
 var cleanCols = flag.Bool("clean-cols", false, "Remove common annoyances in column headers. See tests/README for details.")
+var renameMap = flag.String("rename-map", "", "Rename many columns at once, from a CSV file of old names and new names or from old:new pairs eg: 'Emp No:EMP_ID,Dept:DEPT'")
+var headerCase = flag.String("header-case", "", "Convert column headers to a naming convention. One of "+strings.Join(headerCases, ", "))
+var headerASCII = flag.Bool("header-ascii", false, "Remove characters that aren't printable ASCII from column headers")
+var headerMaxLength = flag.Int("header-max-length", 0, "Shorten column headers to at most this many characters")
+var where = flag.String("where", "", "Only keep rows where the expression is true eg: 'AMOUNT > 0 and PAYCODE in (\"ORD\", \"OT\")'")
+var dedupePolicy = flag.String("dedupe", "", "Drop rows whose --columns match an earlier row. One of first (keep the first of each) or last (keep the last of each). Leave --columns unset to drop exact duplicates")
+var dedupeDuplicates = flag.String("duplicates", "", "Write the rows dropped by --dedupe to this file")
+var dedupeMaxKeys = flag.Int("dedupe-max-keys", defaultMaxKeys, "Number of keys --dedupe holds in memory before moving them to disk")
+var sequenceCol = flag.String("sequence", "", "Add a column numbering the rows from 1")
+var sequenceGroup = flag.String("sequence-group", "", "Number the rows for each value of this column separately")
+var surrogateIDCol = flag.String("surrogate-id", "", "Add a column holding a UUID made from the values of the --columns, so the same record always gets the same ID. Needs --id-namespace and --columns")
+var idNamespace = flag.String("id-namespace", "", "UUID that --surrogate-id makes its IDs within. Use a different one for each kind of record")
+var ifCondition = flag.String("if", "", "Only apply the operations to rows where the expression is true, passing the others through untouched eg: 'PAYCODE = \"ORD\"'. Uses the same syntax as --where")
+var recipeFile = flag.String("recipe", "", "Run the steps in this YAML file in order instead of using the operation flags. See README for details")
+var rejects = flag.String("rejects", "", "Write rows dropped by --where or rejected by another operation to this file instead of discarding them")
+var reportPath = flag.String("report", "", "Write a report of the cells each operation examined and changed to this JSON file, or to stderr if set to stderr")
+var onViolation = flag.String("on-violation", "fail", "What to do with rows that break an --assert rule. One of fail (finish the run then exit with an error), flag (list the broken rules in a _violations column) or reject")
+var evals stringList
+var regexReplaces stringList
+var asserts stringList
+
+func init() {
+	flag.Var(&regexReplaces, "regex-replace", "Replace matches of a regular expression eg: '(\\d+)-(\\d+)=>$2-$1'. May be given more than once, rules run in order")
+	flag.Var(&asserts, "assert", "Check every cell in the target columns passes a rule. One of not-empty, unique, regex:PATTERN, int:MIN..MAX, decimal:MIN..MAX, date:LAYOUT or one-of:A|B|C. May be given more than once")
+	flag.Var(&evals, "eval", "Assign the result of an expression to a new or existing column eg: 'FULL_NAME = upper(FIRST) + \" \" + LAST'. May be given more than once")
+}
+
+// stringList collects every use of a flag that may be given more than once
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ";")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
 
 var columns []string
 
@@ -51,6 +133,8 @@ type flagval struct {
 	active       bool
 	value        string
 	replacements []replacement
+	// options holds the settings of companion flags such as --tz-layout
+	options map[string]string
 }
 
 var logger = util.Logger{}
@@ -66,6 +150,12 @@ func main() {
 		os.Exit(0)
 	}
 
+	tzOptions := map[string]string{
+		"layout":      *tzLayout,
+		"dst-overlap": *dstOverlap,
+		"dst-gap":     *dstGap,
+	}
+
 	flags := map[string]flagval{
 		"stripLeadingZeroes": flagval{
 			active: *stripLeadingZeroes,
@@ -80,6 +170,19 @@ func main() {
 		"commasToPoints": flagval{
 			active: *commasToPoints,
 		},
+		"fillDown": flagval{
+			active: *fillDown,
+			options: map[string]string{
+				"group": *fillGroup,
+			},
+		},
+		"fillUp": flagval{
+			active: *fillUp != 0,
+			value:  strconv.Itoa(*fillUp),
+			options: map[string]string{
+				"group": *fillGroup,
+			},
+		},
 		"addMissing": flagval{
 			active: *addMissing != "",
 			value:  *addMissing,
@@ -104,12 +207,46 @@ func main() {
 			active: *splitOnDelim != "",
 			value:  *splitOnDelim,
 		},
+		"splitInto": flagval{
+			active: *splitInto != "",
+			value:  *splitInto,
+			options: map[string]string{
+				"delimiter": *delimiter,
+				"pad":       *splitPad,
+			},
+		},
+		"explode": flagval{
+			active: *explodeCol,
+			options: map[string]string{
+				"delimiter":    *delimiter,
+				"index-column": *explodeIndex,
+			},
+		},
 		"cp": flagval{
 			active: *cp,
 		},
 		"drop": flagval{
 			active: *drop,
 		},
+		"regexReplace": flagval{
+			active: len(regexReplaces) > 0,
+			value:  strings.Join(regexReplaces, "\n"),
+		},
+		"regexExtract": flagval{
+			active: *regexExtract != "",
+			value:  *regexExtract,
+		},
+		"mapFile": flagval{
+			active: *mapFile != "",
+			value:  *mapFile,
+			options: map[string]string{
+				"from":            *mapFrom,
+				"to":              *mapTo,
+				"default":         *mapDefault,
+				"ignore-case":     strconv.FormatBool(*mapIgnoreCase),
+				"unmapped-report": *mapUnmapped,
+			},
+		},
 		"stompAlphas": flagval{
 			active: *stompAlphas,
 		},
@@ -124,6 +261,30 @@ func main() {
 		"trimWhitespace": flagval{
 			active: *trimWhitespace,
 		},
+		"removeNonPrintable": flagval{
+			active: *removeNonPrintableCols,
+		},
+		"normalizeUnicode": flagval{
+			active: *normalizeUnicode != "",
+			value:  *normalizeUnicode,
+		},
+		"stripAccents": flagval{
+			active: *stripAccentsCols,
+		},
+		"straightenQuotes": flagval{
+			active: *straightenQuotesCols,
+		},
+		"collapseWhitespace": flagval{
+			active: *collapseWhitespaceCols,
+		},
+		"changeCase": flagval{
+			active: *textCase != "",
+			value:  *textCase,
+		},
+		"truncate": flagval{
+			active: *truncateCols >= 0,
+			value:  strconv.Itoa(*truncateCols),
+		},
 		"backToFront": flagval{
 			active: *backToFront != "",
 			value:  *backToFront,
@@ -131,18 +292,159 @@ func main() {
 		"reformatDate": flagval{
 			active: *reformatDate != "",
 			value:  *reformatDate,
+			options: map[string]string{
+				"ambiguity":  *dateAmbiguity,
+				"on-invalid": *onInvalid,
+			},
 		},
 		"reformatTime": flagval{
 			active: *reformatTime != "",
 			value:  *reformatTime,
 		},
-		"reformatDateTime": flagval{
-			active: *reformatDate != "",
-			value:  *reformatDate,
+		"normalizeNumber": flagval{
+			active: *normalizeNumber != "",
+			value:  *normalizeNumber,
+			options: map[string]string{
+				"precision":  strconv.Itoa(*numberPrecision),
+				"rounding":   *rounding,
+				"on-invalid": *onInvalid,
+			},
+		},
+		"tfn": flagval{
+			active: *tfnCols,
+			options: map[string]string{
+				"on-invalid": *onInvalid,
+			},
+		},
+		"abn": flagval{
+			active: *abnCols,
+			options: map[string]string{
+				"on-invalid": *onInvalid,
+			},
+		},
+		"bsb": flagval{
+			active: *bsbCols,
+			options: map[string]string{
+				"on-invalid": *onInvalid,
+			},
+		},
+		"bankAccount": flagval{
+			active: *bankAccountCols,
+			options: map[string]string{
+				"on-invalid": *onInvalid,
+			},
+		},
+		"convertTz": flagval{
+			active:  *convertTz != "",
+			value:   *convertTz,
+			options: tzOptions,
+		},
+		"toUtc": flagval{
+			active:  *toUtc != "",
+			value:   *toUtc,
+			options: tzOptions,
+		},
+		"toEpoch": flagval{
+			active:  *toEpoch != "",
+			value:   *toEpoch,
+			options: tzOptions,
+		},
+		"duration": flagval{
+			active: *duration != "",
+			value:  *duration,
+			options: map[string]string{
+				"unit":         *durationUnit,
+				"layout":       *durationLayout,
+				"precision":    strconv.Itoa(*durationPrecision),
+				"break-column": *breakColumn,
+			},
+		},
+		"tokenise": flagval{
+			active: *tokeniseCols,
+			options: map[string]string{
+				"key-file": *keyFile,
+			},
+		},
+		"mask": flagval{
+			active: *maskCols != "",
+			value:  *maskCols,
+		},
+		"redact": flagval{
+			active: *redactCols,
+		},
+		"null": flagval{
+			active: *nullCols,
 		},
 		"cleanCols": flagval{
 			active: *cleanCols,
 		},
+		"renameMap": flagval{
+			active: *renameMap != "",
+			value:  *renameMap,
+		},
+		"headerCase": flagval{
+			active: *headerCase != "",
+			value:  *headerCase,
+		},
+		"headerAscii": flagval{
+			active: *headerASCII,
+		},
+		"headerMaxLength": flagval{
+			active: *headerMaxLength != 0,
+			value:  strconv.Itoa(*headerMaxLength),
+		},
+		"eval": flagval{
+			active: len(evals) > 0,
+			value:  evals.String(),
+			options: map[string]string{
+				"on-invalid": *onInvalid,
+			},
+		},
+		"where": flagval{
+			active: *where != "",
+			value:  *where,
+		},
+		"dedupe": flagval{
+			active: *dedupePolicy != "",
+			value:  *dedupePolicy,
+			options: map[string]string{
+				"duplicates": *dedupeDuplicates,
+				"max-keys":   strconv.Itoa(*dedupeMaxKeys),
+			},
+		},
+		"sequence": flagval{
+			active: *sequenceCol != "",
+			value:  *sequenceCol,
+			options: map[string]string{
+				"group": *sequenceGroup,
+			},
+		},
+		"surrogateId": flagval{
+			active: *surrogateIDCol != "",
+			value:  *surrogateIDCol,
+			options: map[string]string{
+				"namespace": *idNamespace,
+			},
+		},
+		"assert": flagval{
+			active: len(asserts) > 0,
+			value:  strings.Join(asserts, "\n"),
+			options: map[string]string{
+				"on-violation": *onViolation,
+			},
+		},
+		"rejects": flagval{
+			active: *rejects != "",
+			value:  *rejects,
+		},
+		"report": flagval{
+			active: *reportPath != "",
+			value:  *reportPath,
+		},
+		"if": flagval{
+			active: *ifCondition != "",
+			value:  *ifCondition,
+		},
 	}
 
 	for k, flag := range flags {
@@ -153,7 +455,30 @@ func main() {
 
 	output := csv.NewWriter(os.Stdout)
 
-	if err := gumption(os.Stdin, *output, columns, flags); err != nil {
+	if *recipeFile != "" {
+		for k, flag := range flags {
+			if flag.active && k != "rejects" && k != "report" {
+				logger.Fatal(fmt.Errorf("--recipe cannot be combined with flag %s", k))
+			}
+		}
+		if len(columns) > 0 {
+			logger.Fatal(fmt.Errorf("--recipe cannot be combined with --columns, set columns on each step instead"))
+		}
+
+		f, err := os.Open(*recipeFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		operations, err := loadRecipe(f)
+		f.Close()
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		if err := runOperations(os.Stdin, *output, operations, true, *rejects, *reportPath); err != nil {
+			logger.Fatal(err)
+		}
+	} else if err := gumption(os.Stdin, *output, columns, flags); err != nil {
 		logger.Fatal(err)
 	}
 
@@ -163,277 +488,16 @@ func main() {
 }
 
 func gumption(input io.Reader, output csv.Writer, columns []string, flags map[string]flagval) error {
-	cachedHeaders := []string{}
-
 	for i, col := range columns {
 		columns[i] = strings.ReplaceAll(col, "GUMPTION_LITERAL_COMMA", ",")
 	}
 
-	alphas, err := regexp.Compile("[a-zA-Z]+")
+	operations, err := flagOperations(columns, flags)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	handleHeaders := func(headers []string) ([]string, error) {
-		if len(cachedHeaders) > 0 {
-			return cachedHeaders, nil
-		}
-
-		if os.Getenv("NO_STRIP_BOM") != "true" {
-			headers[0] = strings.TrimLeftFunc(headers[0], func(r rune) bool {
-				return r == '\uFEFF'
-			})
-		}
-
-		cachedHeaders = append([]string{}, headers...)
-
-		if len(columns) == 0 {
-			columns = append([]string{}, cachedHeaders...)
-		}
-
-		if flags["rename"].active {
-			if len(columns) > 1 {
-				return []string{}, fmt.Errorf("Can only rename one column at at a time")
-			}
-			if len(columns) == 0 {
-				return []string{}, fmt.Errorf("Cannot rename without setting a single target column")
-			}
-			for i, header := range cachedHeaders {
-				if header == columns[0] {
-					cachedHeaders[i] = flags["rename"].value
-				}
-			}
-		}
-
-		if flags["splitOnDelim"].active {
-			for _, col := range columns {
-				cachedHeaders = append(cachedHeaders, suffixed(col, columns, 1))
-			}
-		}
-
-		if flags["cp"].active {
-			for _, col := range columns {
-				cachedHeaders = append(cachedHeaders, suffixed(col, columns, 1))
-			}
-		}
-
-		if flags["drop"].active {
-			newHeaders := []string{}
-			for _, header := range cachedHeaders {
-				shouldDrop := false
-				for _, col := range columns {
-					if col == header {
-						shouldDrop = true
-					}
-				}
-				if !shouldDrop {
-					newHeaders = append(newHeaders, header)
-				}
-			}
-			cachedHeaders = newHeaders
-		}
-
-		if flags["cleanCols"].active {
-			for i, header := range cachedHeaders {
-				for _, col := range columns {
-					if col == header {
-						header = cleanCol(header)
-					}
-				}
-				cachedHeaders[i] = header
-			}
-		}
-
-		if err := output.Write(cachedHeaders); err != nil {
-			return []string{}, err
-		}
-		output.Flush()
-
-		return cachedHeaders, nil
-	}
-
-	work, errors := util.ReadSourceAsync(input)
-
-	var cachedErr error
-	go (func() {
-		for err := range errors {
-			log.Println("ERROR", err)
-			cachedErr = err
-		}
-	})()
-
-	for line := range work {
-		headers, err := handleHeaders(line.Headers)
-		if err != nil {
-			return fmt.Errorf("Error handling headers %w", err)
-		}
-
-		shouldDelete := false
-
-		for _, col := range columns {
-			cell := line.Data[col]
-			if flags["cleanCols"].active {
-				col = cleanCol(col)
-			}
-			if flags["stripLeadingZeroes"].active {
-				cell = strings.TrimLeft(cell, "0")
-			}
-
-			if flags["leftPad"].active {
-				parts := strings.Split(flags["leftPad"].value, ",")
-				pad := parts[0]
-				length, err := strconv.Atoi(parts[1])
-
-				if err != nil {
-					log.Println("WARN ignoring garbled cell", col, line.Data[col])
-				} else {
-					for i := len(cell); i < length; i++ {
-						cell = pad + cell
-					}
-				}
-			}
-
-			if flags["unquote"].active {
-				cell = strings.Trim(cell, `"`)
-				cell = strings.Trim(cell, `'`)
-			}
-
-			if flags["commasToPoints"].active {
-				cell = strings.ReplaceAll(cell, ",", ".")
-			}
-
-			if flags["addMissing"].active {
-				if cell == "" {
-					cell = flags["addMissing"].value
-				}
-			}
-
-			if flags["replaceCell"].active {
-				for _, rep := range flags["replaceCell"].replacements {
-					if cell == rep.from {
-						cell = rep.to
-					}
-				}
-			}
-
-			if flags["replaceCellLookup"].active {
-				for _, rep := range flags["replaceCellLookup"].replacements {
-					if cell == rep.from {
-						cell = line.Data[rep.to]
-					}
-				}
-			}
-
-			if flags["replaceChar"].active {
-				for _, rep := range flags["replaceChar"].replacements {
-					cell = strings.ReplaceAll(cell, rep.from, rep.to)
-				}
-			}
-
-			if flags["stompAlphas"].active {
-				cell = alphas.ReplaceAllString(cell, "")
-			}
-
-			line.Data[col] = cell
-
-			if flags["rename"].active {
-				line.Data[flags["rename"].value] = cell
-			}
-
-			if flags["splitOnDelim"].active {
-				parts := strings.SplitN(cell, flags["splitOnDelim"].value, 2)
-				if len(parts) > 1 {
-					line.Data[col] = parts[0]
-					line.Data[suffixed(col, columns, 1)] = parts[1]
-				}
-			}
-
-			if flags["cp"].active {
-				line.Data[suffixed(col, columns, 1)] = cell
-			}
-
-			if flags["deleteWhere"].active {
-				if line.Data[col] == flags["deleteWhere"].value {
-					shouldDelete = true
-				}
-			}
-
-			if flags["deleteWhereNot"].active {
-				if line.Data[col] != flags["deleteWhereNot"].value {
-					shouldDelete = true
-				}
-			}
-
-			if flags["trimWhitespace"].active {
-				line.Data[col] = strings.Trim(line.Data[col], " ")
-			}
-
-			if flags["backToFront"].active {
-				i := len(line.Data[col]) - 1
-				if i < 0 {
-					continue
-				}
-				lastChar := line.Data[col][i:]
-				if lastChar == flags["backToFront"].value {
-					line.Data[col] = flags["backToFront"].value + line.Data[col][:i]
-				}
-			}
-
-			if flags["reformatDate"].active {
-				format := strings.ReplaceAll(flags["reformatDate"].value, "YYYY", "2006")
-				format = strings.ReplaceAll(format, "YY", "06")
-				format = strings.ReplaceAll(format, "MM", "01")
-				format = strings.ReplaceAll(format, "SHORTMONTH", "Jan")
-				format = strings.ReplaceAll(format, "DD", "02")
-
-				// hours
-				format = strings.ReplaceAll(format, "hh", "15")
-				// minutes
-				format = strings.ReplaceAll(format, "mm", "04")
-				// seconds
-				format = strings.ReplaceAll(format, "ss", "05")
-
-				inputLayout := strings.Split(format, ",")[0]
-				outputLayout := strings.Split(format, ",")[1]
-
-				t, err := time.Parse(inputLayout, line.Data[col])
-				if err != nil {
-					log.Println("WARN ignoring garbled date", col, line.Data[col])
-				} else {
-					line.Data[col] = t.Format(outputLayout)
-				}
-			}
-
-			if flags["reformatTime"].active {
-				format := strings.ReplaceAll(flags["reformatTime"].value, "HH", "15")
-				format = strings.ReplaceAll(format, "MM", "04")
-				format = strings.ReplaceAll(format, "SS", "05")
-
-				inputLayout := strings.Split(format, ",")[0]
-				outputLayout := strings.Split(format, ",")[1]
-
-				t, err := time.Parse(inputLayout, line.Data[col])
-				if err != nil {
-					log.Println("WARN ignoring garbled time", col, line.Data[col])
-				} else {
-					line.Data[col] = t.Format(outputLayout)
-				}
-			}
-		}
-
-		newLine := []string{}
-		for _, header := range headers {
-			newLine = append(newLine, line.Data[header])
-		}
-		if !shouldDelete {
-			if err = output.Write(newLine); err != nil {
-				return err
-			}
-		}
-		output.Flush()
+		return err
 	}
 
-	return cachedErr
+	return runOperations(input, output, operations, false, flags["rejects"].value, flags["report"].value)
 }
 
 func suffixed(target string, cols []string, i int) string {
@@ -2,9 +2,17 @@ package main
 
 import (
 	"encoding/csv"
+	"go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/paidright/datalab/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -148,6 +156,31 @@ func TestGumption(t *testing.T) {
 123,abc`,
 			want: []string{"asd,two"},
 		},
+		{
+			flags: map[string]flagval{
+				"regexReplace": flagval{
+					active: true,
+					value:  "(\\d{2})/(\\d{2})=>$2-$1\n(?i)^ord$=>ORDINARY",
+				},
+			},
+			input: `one,two
+12/34,Ord
+56/78,OT`,
+			want: []string{"one,two\n34-12,ORDINARY\n78-56,OT\n"},
+		},
+		{
+			flags: map[string]flagval{
+				"regexExtract": flagval{
+					active: true,
+					value:  `(?i)emp\s*#?(\d+)=>EMPLOYEE_ID`,
+				},
+			},
+			cols: []string{"desc"},
+			input: `id,desc
+1,Overtime for Emp #1234 approved
+2,no number here`,
+			want: []string{"id,desc,EMPLOYEE_ID\n1,Overtime for Emp #1234 approved,1234\n2,no number here,\n"},
+		},
 		{
 			flags: map[string]flagval{
 				"splitOnDelim": flagval{
@@ -373,6 +406,67 @@ lolwut,hurr,foo,bar,baz`,
 20150629083000,foo`,
 			want: []string{"one,two", "2015-06-29 08:30:00,foo"},
 		},
+		{
+			flags: map[string]flagval{
+				"eval": flagval{
+					active: true,
+					value:  `FULL_NAME = upper(FIRST) + " " + LAST; PAY = round(RATE * HOURS, 2)`,
+				},
+			},
+			input: `FIRST,LAST,RATE,HOURS
+ada,Lovelace,25.50,7.5
+alan,Turing,30,x`,
+			want: []string{
+				"FIRST,LAST,RATE,HOURS,FULL_NAME,PAY",
+				"ada,Lovelace,25.50,7.5,ADA Lovelace,191.25",
+				"alan,Turing,30,x,ALAN Turing,",
+			},
+		},
+		{
+			flags: map[string]flagval{
+				"eval": flagval{
+					active: true,
+					value:  `LAST = coalesce(LAST, "unknown")`,
+				},
+			},
+			input: `FIRST,LAST
+ada,
+alan,Turing`,
+			want: []string{"FIRST,LAST\n", "ada,unknown", "alan,Turing"},
+		},
+		{
+			flags: map[string]flagval{
+				"where": flagval{
+					active: true,
+					value:  `HOURS > 7 and PAYCODE in ("ORD", "OT")`,
+				},
+			},
+			input: `ID,PAYCODE,HOURS
+1,ORD,7.5
+2,ORD,6
+3,LEAVE,8
+4,OT,10`,
+			want:         []string{"ID,PAYCODE,HOURS", "1,ORD,7.5", "4,OT,10"},
+			demandLength: 4,
+		},
+		{
+			flags: map[string]flagval{
+				"eval": flagval{
+					active: true,
+					value:  `PAY = RATE * HOURS`,
+				},
+				"where": flagval{
+					active: true,
+					value:  `PAY >= 100 or NOTE is not empty`,
+				},
+			},
+			input: `RATE,HOURS,NOTE
+10,10,
+10,5,
+10,5,keep me`,
+			want:         []string{"RATE,HOURS,NOTE,PAY", "10,10,,100", "10,5,keep me,50"},
+			demandLength: 4,
+		},
 	}
 
 	for _, tc := range tests {
@@ -408,3 +502,381 @@ func TestParseReplacements(t *testing.T) {
 
 	assert.Equal(t, expected, parseReplacements(input))
 }
+
+func TestEvalUnknownColumn(t *testing.T) {
+	flags := map[string]flagval{
+		"eval": flagval{
+			active: true,
+			value:  `NEW = upper(MISSING)`,
+		},
+	}
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.NotNil(t, gumption(strings.NewReader("one,two\n1,2"), *writer, []string{}, flags))
+}
+
+func TestEvalOnInvalid(t *testing.T) {
+	input := `RATE,HOURS,PAY
+10,2,old
+10,x,old`
+
+	tests := []struct {
+		onInvalid string
+		want      string
+		fails     bool
+	}{
+		{"keep", "RATE,HOURS,PAY\n10,2,20\n10,x,old\n", false},
+		{"blank", "RATE,HOURS,PAY\n10,2,20\n10,x,\n", false},
+		{"flag", "RATE,HOURS,PAY,PAY_valid\n10,2,20,true\n10,x,old,false\n", false},
+		{"reject", "RATE,HOURS,PAY\n10,2,20\n", false},
+		{"fail", "RATE,HOURS,PAY\n10,2,20\n10,x,old\n", true},
+	}
+
+	for _, tc := range tests {
+		flags := map[string]flagval{
+			"eval": flagval{
+				active:  true,
+				value:   `PAY = RATE * HOURS`,
+				options: map[string]string{"on-invalid": tc.onInvalid},
+			},
+		}
+
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		err := gumption(strings.NewReader(input), *writer, []string{}, flags)
+		assert.Equal(t, tc.fails, err != nil, tc.onInvalid)
+		writer.Flush()
+
+		assert.Equal(t, tc.want, result.String(), tc.onInvalid)
+	}
+}
+
+func TestSplitInto(t *testing.T) {
+	flags := map[string]flagval{
+		"splitInto": flagval{
+			active: true,
+			value:  "YEAR,MONTH,DAY",
+			options: map[string]string{
+				"delimiter": "-",
+				"pad":       "01",
+			},
+		},
+	}
+
+	input := `ID,DATE
+1,2020-02-03
+2,2020-02
+3,2020-02-03-extra
+4,`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"DATE"}, flags))
+	writer.Flush()
+
+	assert.Equal(t, `ID,DATE,YEAR,MONTH,DAY
+1,2020-02-03,2020,02,03
+2,2020-02,2020,02,01
+3,2020-02-03-extra,2020,02,03-extra
+4,,,01,01
+`, result.String())
+}
+
+func TestExplode(t *testing.T) {
+	recipe := `steps:
+  - op: explode
+    columns: [DAYS]
+  - op: where
+    value: DAYS != "SUN"
+  - op: eval
+    value: SHIFT = ID + "-" + DAYS_index`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	input := `ID,DAYS,HOURS
+1,MON;TUE;SUN,7.5
+2,,8
+3,WED,6`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader(input), *writer, operations, true, "", ""))
+	writer.Flush()
+
+	assert.Equal(t, `ID,DAYS,HOURS,DAYS_index,SHIFT
+1,MON,7.5,1,1-1
+1,TUE,7.5,2,1-2
+2,,8,1,2-1
+3,WED,6,1,3-1
+`, result.String())
+}
+
+func TestRegexErrors(t *testing.T) {
+	for _, flags := range []map[string]flagval{
+		{"regexReplace": flagval{active: true, value: "no arrow"}},
+		{"regexReplace": flagval{active: true, value: "([a-z]=>x"}},
+		{"regexExtract": flagval{active: true, value: "(\\d+)=>NUM"}},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.NotNil(t, gumption(strings.NewReader("one,two\n1,2"), *writer, []string{}, flags))
+	}
+}
+
+func TestWhereRejects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rejectsPath := path.Join(dir, "rejects.csv")
+
+	flags := map[string]flagval{
+		"trimWhitespace": flagval{
+			active: true,
+		},
+		"where": flagval{
+			active: true,
+			value:  `date(START) >= date("2020-01-01")`,
+		},
+		"rejects": flagval{
+			active: true,
+			value:  rejectsPath,
+		},
+	}
+
+	input := `ID,START
+1, 2020-02-01
+2,2019-12-31
+3,garbage`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{}, flags))
+	writer.Flush()
+
+	assert.Equal(t, "ID,START\n1,2020-02-01\n", result.String())
+
+	rejected, err := ioutil.ReadFile(rejectsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, `ID,START,gumption_reject_reason
+2,2019-12-31,where
+3,garbage,"date: cannot use ""garbage"" as a date"
+`, string(rejected))
+}
+
+func TestRecipe(t *testing.T) {
+	recipe := `steps:
+  - op: trim-whitespace
+    columns: [NAME]
+  - op: reformat-date
+    columns: [START]
+    value: DD.MM.YYYY,YYYY-MM-DD
+  - op: rename
+    columns: [START]
+    value: START_DATE
+  - op: copy
+    columns: [START_DATE]
+  - op: eval
+    value: LABEL = NAME + "@" + START_DATE_1
+  - op: drop
+    columns: [START_DATE_1]
+`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	input := `NAME,START
+  Alice ,01.02.2020
+Bob,garbage`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader(input), *writer, operations, true, "", ""))
+	writer.Flush()
+
+	assert.Equal(t, `NAME,START_DATE,LABEL
+Alice,2020-02-01,Alice@2020-02-01
+Bob,garbage,Bob@garbage
+`, result.String())
+}
+
+func TestOperationNames(t *testing.T) {
+	for _, name := range operationOrder {
+		assert.Equal(t, name, operationNames[flagName(name)], name)
+	}
+	assert.Equal(t, len(operationOrder), len(operationNames))
+}
+
+// TestOperationRegistry checks that every operation given a flag in main is
+// known to recipes and is handled by the pipeline, so a new operation can't
+// be half added
+func TestOperationRegistry(t *testing.T) {
+	fset := gotoken.NewFileSet()
+
+	mainFile, err := goparser.ParseFile(fset, "main.go", nil, 0)
+	assert.Nil(t, err)
+	pipelineFile, err := goparser.ParseFile(fset, "pipeline.go", nil, 0)
+	assert.Nil(t, err)
+
+	// The keys of the flags map built by main, leaving out the settings that
+	// aren't operations
+	settings := []string{"if", "rejects", "report"}
+	flags := []string{}
+	ast.Inspect(mainFile, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+			return true
+		}
+		if ident, ok := assign.Lhs[0].(*ast.Ident); !ok || ident.Name != "flags" {
+			return true
+		}
+		if literal, ok := assign.Rhs[0].(*ast.CompositeLit); ok {
+			for _, elt := range literal.Elts {
+				name := stringLiteral(elt.(*ast.KeyValueExpr).Key)
+				if !util.Contains(name, settings) {
+					flags = append(flags, name)
+				}
+			}
+		}
+		return true
+	})
+	assert.NotEmpty(t, flags)
+
+	// The operations prepare and apply do something for, going by their
+	// switches on op.name and comparisons with it
+	handled := []string{}
+	isOpName := func(e ast.Expr) bool {
+		sel, ok := e.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "name" {
+			return false
+		}
+		ident, ok := sel.X.(*ast.Ident)
+		return ok && ident.Name == "op"
+	}
+	for _, decl := range pipelineFile.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || (fn.Name.Name != "prepare" && fn.Name.Name != "apply") {
+			continue
+		}
+		ast.Inspect(fn, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SwitchStmt:
+				if !isOpName(n.Tag) {
+					return true
+				}
+				for _, stmt := range n.Body.List {
+					for _, e := range stmt.(*ast.CaseClause).List {
+						handled = append(handled, stringLiteral(e))
+					}
+				}
+			case *ast.BinaryExpr:
+				if n.Op == gotoken.EQL && isOpName(n.X) {
+					handled = append(handled, stringLiteral(n.Y))
+				}
+			}
+			return true
+		})
+	}
+
+	for _, name := range flags {
+		assert.Contains(t, operationOrder, name, "%s is missing from operationOrder", name)
+		assert.Equal(t, name, operationNames[flagName(name)], "%s has no recipe name", name)
+		assert.Contains(t, handled, name, "%s has no case in prepare or apply", name)
+	}
+	for _, name := range operationOrder {
+		assert.Contains(t, flags, name, "%s has no flag", name)
+	}
+
+	lists := map[string][]string{
+		"rowOperations":           rowOperations,
+		"unconditionalOperations": unconditionalOperations,
+		"switchOperations":        switchOperations,
+		"replacementOperations":   replacementOperations,
+	}
+	for list, names := range lists {
+		for _, name := range names {
+			assert.Contains(t, operationOrder, name, "%s in %s is not an operation", name, list)
+		}
+	}
+	for name := range operationOptions {
+		assert.Contains(t, operationOrder, name, "%s in operationOptions is not an operation", name)
+	}
+}
+
+// stringLiteral unquotes a string literal, returning "" for anything else
+func stringLiteral(e ast.Expr) string {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != gotoken.STRING {
+		return ""
+	}
+	s, _ := strconv.Unquote(lit.Value)
+	return s
+}
+
+func TestRecipeValidation(t *testing.T) {
+	badRecipes := map[string]string{
+		"unknown operation": `steps:
+  - op: frobnicate`,
+		"needs a value": `steps:
+  - op: rename
+    columns: [A]`,
+		"does not take a value": `steps:
+  - op: trim-whitespace
+    value: yes please`,
+		"does not take columns": `steps:
+  - op: where
+    columns: [A]
+    value: A > 1`,
+		"field colums not found": `steps:
+  - op: drop
+    colums: [A]`,
+		"no steps": `steps: []`,
+		"unknown option dst-gap": `steps:
+  - op: trim-whitespace
+    options:
+      dst-gap: shift`,
+	}
+
+	for want, recipe := range badRecipes {
+		_, err := loadRecipe(strings.NewReader(recipe))
+		if assert.NotNil(t, err, want) {
+			assert.Contains(t, err.Error(), want)
+		}
+	}
+
+	headerErrors := map[string]string{
+		"step 2 (trim-whitespace): unknown column A": `steps:
+  - op: rename
+    columns: [A]
+    value: B
+  - op: trim-whitespace
+    columns: [A]`,
+		"step 1 (left-pad): left pad expects": `steps:
+  - op: left-pad
+    value: "0"`,
+	}
+
+	for want, recipe := range headerErrors {
+		operations, err := loadRecipe(strings.NewReader(recipe))
+		assert.Nil(t, err)
+
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		err = runOperations(strings.NewReader("A,C\n1,2"), *writer, operations, true, "", "")
+		if assert.NotNil(t, err, want) {
+			assert.Contains(t, err.Error(), want)
+		}
+		writer.Flush()
+		assert.Equal(t, "", result.String())
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paidright/datalab/util"
+)
+
+// maxUnmappedLogged caps how many unmapped values are listed in the log. The
+// full list goes to the unmapped report.
+const maxUnmappedLogged = 20
+
+// valueMap rewrites cells using a dictionary loaded from a CSV file
+type valueMap struct {
+	values     map[string]string
+	ignoreCase bool
+	fallback   string
+	// unmapped counts how often each value without a mapping was seen
+	unmapped map[string]int
+	report   string
+}
+
+func newValueMap(op *operation) (*valueMap, error) {
+	from, to := op.option("from", ""), op.option("to", "")
+	if from == "" || to == "" {
+		return nil, fmt.Errorf("map-file needs the columns to map from and to eg: --map-from code --map-to description")
+	}
+
+	m := valueMap{
+		values:     map[string]string{},
+		ignoreCase: op.option("ignore-case", "false") == "true",
+		fallback:   op.option("default", ""),
+		unmapped:   map[string]int{},
+		report:     op.option("unmapped-report", ""),
+	}
+
+	headers, err := util.ReadHeaders(op.flag.value)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range []string{from, to} {
+		if !util.Contains(col, headers) {
+			return nil, fmt.Errorf("unknown column %s in %s", col, op.flag.value)
+		}
+	}
+
+	err = util.ReadFile(op.flag.value, func(line map[string]string, headers []string, lineNumber int) error {
+		key := m.key(line[from])
+		if existing, ok := m.values[key]; ok && existing != line[to] {
+			return fmt.Errorf("%s maps to both %s and %s", line[from], existing, line[to])
+		}
+		m.values[key] = line[to]
+		return nil
+	})
+
+	return &m, err
+}
+
+func (m *valueMap) key(value string) string {
+	if m.ignoreCase {
+		return strings.ToLower(value)
+	}
+	return value
+}
+
+// lookup finds the mapping for a cell, reporting whether it was mapped,
+// defaulted or left unmapped
+func (m *valueMap) lookup(cell string) (string, string) {
+	if mapped, ok := m.values[m.key(cell)]; ok {
+		return mapped, "mapped"
+	}
+	m.unmapped[cell]++
+	if m.fallback != "" {
+		return m.fallback, "defaulted"
+	}
+	return cell, "unmapped"
+}
+
+// unmappedValues lists the values seen without a mapping, most common first
+func (m *valueMap) unmappedValues() []string {
+	values := []string{}
+	for value := range m.unmapped {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		a, b := m.unmapped[values[i]], m.unmapped[values[j]]
+		if a != b {
+			return a > b
+		}
+		return values[i] < values[j]
+	})
+	return values
+}
+
+// finish logs the unmapped values and writes them to the report if asked
+func (m *valueMap) finish() error {
+	values := m.unmappedValues()
+
+	if len(values) > 0 {
+		listed := []string{}
+		for i, value := range values {
+			if i == maxUnmappedLogged {
+				listed = append(listed, fmt.Sprintf("and %d more", len(values)-i))
+				break
+			}
+			listed = append(listed, fmt.Sprintf("%q (%d)", value, m.unmapped[value]))
+		}
+		logger.Info("unmapped values:", strings.Join(listed, ", "))
+	}
+
+	if m.report == "" {
+		return nil
+	}
+
+	f, err := os.Create(m.report)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"value", "count"}); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := w.Write([]string{value, strconv.Itoa(m.unmapped[value])}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mapPath := path.Join(dir, "paycodes.csv")
+	assert.Nil(t, ioutil.WriteFile(mapPath, []byte(`code,description,notes
+ORD,Ordinary hours,
+OT15,Overtime x1.5,
+ot15,Overtime x1.5,same thing
+`), 0600))
+
+	reportPath := path.Join(dir, "unmapped.csv")
+
+	input := `ID,PAYCODE
+1,ORD
+2,ord
+3,OT15
+4,LEAVE
+5,BONUS
+6,LEAVE`
+
+	type mapTest struct {
+		options map[string]string
+		want    string
+	}
+
+	tests := []mapTest{
+		{
+			options: map[string]string{"from": "code", "to": "description"},
+			want:    "ID,PAYCODE\n1,Ordinary hours\n2,ord\n3,Overtime x1.5\n4,LEAVE\n5,BONUS\n6,LEAVE\n",
+		},
+		{
+			options: map[string]string{"from": "code", "to": "description", "ignore-case": "true", "default": "Other", "unmapped-report": reportPath},
+			want:    "ID,PAYCODE\n1,Ordinary hours\n2,Ordinary hours\n3,Overtime x1.5\n4,Other\n5,Other\n6,Other\n",
+		},
+	}
+
+	for _, tc := range tests {
+		flags := map[string]flagval{
+			"mapFile": flagval{
+				active:  true,
+				value:   mapPath,
+				options: tc.options,
+			},
+		}
+
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"PAYCODE"}, flags))
+		writer.Flush()
+
+		assert.Equal(t, tc.want, result.String())
+	}
+
+	report, err := ioutil.ReadFile(reportPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "value,count\nLEAVE,2\nBONUS,1\n", string(report))
+}
+
+func TestMapFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mapPath := path.Join(dir, "paycodes.csv")
+	assert.Nil(t, ioutil.WriteFile(mapPath, []byte("code,description\nORD,Ordinary\nORD,Overtime\n"), 0600))
+
+	for _, f := range []flagval{
+		{active: true, value: mapPath, options: map[string]string{"from": "code"}},
+		{active: true, value: mapPath, options: map[string]string{"from": "code", "to": "missing"}},
+		{active: true, value: path.Join(dir, "missing.csv"), options: map[string]string{"from": "code", "to": "description"}},
+		{active: true, value: mapPath, options: map[string]string{"from": "code", "to": "description"}},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.NotNil(t, gumption(strings.NewReader("PAYCODE\nORD"), *writer, []string{}, map[string]flagval{"mapFile": f}))
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/paidright/datalab/util"
+)
+
+// numberLocale describes how a locale writes numbers
+type numberLocale struct {
+	decimal rune
+	// thousands lists every character the locale uses to group digits
+	thousands []rune
+}
+
+var (
+	pointDecimal = numberLocale{decimal: '.', thousands: []rune{','}}
+	commaDecimal = numberLocale{decimal: ',', thousands: []rune{'.'}}
+	// spaceDecimal groups with a space, which is often a non-breaking one
+	spaceDecimal = numberLocale{decimal: ',', thousands: []rune{' ', '\u00a0', '\u202f'}}
+	swissDecimal = numberLocale{decimal: '.', thousands: []rune{'\'', '\u2019'}}
+)
+
+// numberLocales is keyed by language, optionally with a region. Lookups fall
+// back from the region to the language.
+var numberLocales = map[string]numberLocale{
+	"en":    pointDecimal,
+	"ja":    pointDecimal,
+	"zh":    pointDecimal,
+	"de":    commaDecimal,
+	"es":    commaDecimal,
+	"it":    commaDecimal,
+	"nl":    commaDecimal,
+	"pt":    commaDecimal,
+	"id":    commaDecimal,
+	"da":    commaDecimal,
+	"tr":    commaDecimal,
+	"fr":    spaceDecimal,
+	"sv":    spaceDecimal,
+	"nb":    spaceDecimal,
+	"fi":    spaceDecimal,
+	"pl":    spaceDecimal,
+	"cs":    spaceDecimal,
+	"ru":    spaceDecimal,
+	"de-CH": swissDecimal,
+	"fr-CH": swissDecimal,
+	"it-CH": swissDecimal,
+}
+
+func lookupNumberLocale(name string) (numberLocale, bool) {
+	name = strings.ReplaceAll(name, "_", "-")
+	if locale, ok := numberLocales[name]; ok {
+		return locale, true
+	}
+	language := strings.ToLower(strings.Split(name, "-")[0])
+	locale, ok := numberLocales[language]
+	return locale, ok
+}
+
+// numberNormaliser turns numbers written for people into plain decimals
+type numberNormaliser struct {
+	locale numberLocale
+	// precision is the number of decimal places to write, or -1 to write as
+	// many as are needed
+	precision int
+	rounding  util.RoundingMode
+	onInvalid string
+}
+
+func newNumberNormaliser(op *operation) (*numberNormaliser, error) {
+	locale, ok := lookupNumberLocale(op.flag.value)
+	if !ok {
+		return nil, fmt.Errorf("unknown locale %s", op.flag.value)
+	}
+
+	precision, err := strconv.Atoi(op.option("precision", "-1"))
+	if err != nil || precision < -1 {
+		return nil, fmt.Errorf("precision must be a whole number of decimal places")
+	}
+
+	n := numberNormaliser{
+		locale:    locale,
+		precision: precision,
+		rounding:  util.RoundingMode(op.option("rounding", string(util.RoundHalfEven))),
+		onInvalid: op.option("on-invalid", "keep"),
+	}
+
+	if !util.Contains(string(n.rounding), util.RoundingModes) {
+		return nil, fmt.Errorf("rounding must be one of %s", strings.Join(util.RoundingModes, ", "))
+	}
+	if !util.Contains(n.onInvalid, invalidModes) {
+		return nil, fmt.Errorf("on-invalid must be one of %s", strings.Join(invalidModes, ", "))
+	}
+
+	return &n, nil
+}
+
+func (n *numberNormaliser) format(r *big.Rat) string {
+	if n.precision < 0 {
+		return util.FormatDecimal(r)
+	}
+	return util.RoundDecimalMode(r, n.precision, n.rounding).FloatString(n.precision)
+}
+
+// currencyPrefix matches symbols such as A$, US$ or R$
+var currencyPrefix = regexp.MustCompile(`^[A-Z]{1,3}\p{Sc}`)
+
+// isCurrencyCode spots ISO 4217 codes such as AUD
+func isCurrencyCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// parse reads a number such as "(AUD 1,234.50)", "1.234,5-" or "12,5 %"
+func (n *numberNormaliser) parse(cell string) (*big.Rat, bool) {
+	s := strings.TrimSpace(cell)
+	negative, signed, percent := false, false, false
+
+	// Only one sign is allowed, whether it is a -, a + or brackets
+	setSign := func(r rune) bool {
+		if signed {
+			return false
+		}
+		signed = true
+		negative = r == '-'
+		return true
+	}
+
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+		setSign('-')
+	}
+
+	// Peel signs, currency and percentages off both ends until only the
+	// digits are left
+	for {
+		before := s
+		s = strings.TrimFunc(s, unicode.IsSpace)
+		if s == "" {
+			return nil, false
+		}
+
+		first, last := []rune(s)[0], []rune(s)[len([]rune(s))-1]
+		switch {
+		case first == '-' || first == '+':
+			if !setSign(first) {
+				return nil, false
+			}
+			s = s[1:]
+		case last == '-' || last == '+':
+			if !setSign(last) {
+				return nil, false
+			}
+			s = s[:len(s)-1]
+		case last == '%' && !percent:
+			percent = true
+			s = s[:len(s)-1]
+		case currencyPrefix.MatchString(s):
+			s = currencyPrefix.ReplaceAllString(s, "")
+		case unicode.Is(unicode.Sc, first):
+			s = strings.TrimPrefix(s, string(first))
+		case unicode.Is(unicode.Sc, last):
+			s = strings.TrimSuffix(s, string(last))
+		case len(s) > 3 && isCurrencyCode(s[:3]):
+			s = s[3:]
+		case len(s) > 3 && isCurrencyCode(s[len(s)-3:]):
+			s = s[:len(s)-3]
+		}
+
+		if s == before {
+			break
+		}
+	}
+
+	digits, ok := n.digits(s)
+	if !ok {
+		return nil, false
+	}
+
+	r, ok := util.ParseDecimal(digits)
+	if !ok {
+		return nil, false
+	}
+	if negative {
+		r.Neg(r)
+	}
+	if percent {
+		r.Quo(r, big.NewRat(100, 1))
+	}
+	return r, true
+}
+
+// digits strips the thousands separators from a number, checking that they
+// split it into groups of three, and swaps the decimal separator for a point
+func (n *numberNormaliser) digits(s string) (string, bool) {
+	whole, fraction, hasFraction := s, "", false
+	if i := strings.LastIndex(s, string(n.locale.decimal)); i >= 0 {
+		whole, fraction, hasFraction = s[:i], s[i+len(string(n.locale.decimal)):], true
+	}
+
+	if hasFraction && !isDigits(fraction) {
+		return "", false
+	}
+	if whole == "" {
+		return "0." + fraction, hasFraction
+	}
+
+	for _, sep := range n.locale.thousands {
+		whole = strings.ReplaceAll(whole, string(sep), ",")
+	}
+	groups := strings.Split(whole, ",")
+	for i, group := range groups {
+		if !isDigits(group) {
+			return "", false
+		}
+		if len(groups) > 1 && (len(group) > 3 || (i > 0 && len(group) != 3)) {
+			return "", false
+		}
+	}
+
+	digits := strings.Join(groups, "")
+	if hasFraction {
+		digits += "." + fraction
+	}
+	return digits, true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeNumber(t *testing.T) {
+	type numberTest struct {
+		locale string
+		cell   string
+		want   string
+	}
+
+	tests := []numberTest{
+		{"en-AU", "1,234.50", "1234.5"},
+		{"en-AU", "$1,234.50", "1234.5"},
+		{"en-AU", "-$1,234.50", "-1234.5"},
+		{"en-AU", "$-1,234.50", "-1234.5"},
+		{"en-AU", "(1,234.50)", "-1234.5"},
+		{"en-AU", "($ 12.00)", "-12"},
+		{"en-AU", "1234.50-", "-1234.5"},
+		{"en-AU", "AUD 99", "99"},
+		{"en-AU", "99 AUD", "99"},
+		{"en-AU", "12.5%", "0.125"},
+		{"en-AU", ".5", "0.5"},
+		{"en-AU", "+7", "7"},
+		{"de", "1.234,50 €", "1234.5"},
+		{"de-DE", "-0,75", "-0.75"},
+		{"fr", "1 234,50", "1234.5"},
+		{"fr-FR", "1 234 567,89", "1234567.89"},
+		{"de-CH", "1'234.50", "1234.5"},
+		{"pt_BR", "R$ 1.234,56", "1234.56"},
+		{"en", "US$5", "5"},
+	}
+
+	for _, tc := range tests {
+		n, err := newNumberNormaliser(&operation{flag: flagval{value: tc.locale}})
+		assert.Nil(t, err)
+
+		r, ok := n.parse(tc.cell)
+		if assert.True(t, ok, tc.cell) {
+			assert.Equal(t, tc.want, n.format(r), tc.cell)
+		}
+	}
+
+	invalid := []string{"abc", "1,23.4", "12,34", "1.2.3", "--5", "-5-", "+5-", "-5+", "+5+", "(-5)", "(+5)", "5 CR", "1e5", "$", "-", "12.", "1,,234"}
+
+	n, err := newNumberNormaliser(&operation{flag: flagval{value: "en"}})
+	assert.Nil(t, err)
+	for _, cell := range invalid {
+		_, ok := n.parse(cell)
+		assert.False(t, ok, cell)
+	}
+}
+
+func TestNormalizeNumberPrecision(t *testing.T) {
+	input := `ID,AMOUNT
+1,"$1,234.565"
+2,(0.125)
+3,N/A
+4,`
+
+	type precisionTest struct {
+		options map[string]string
+		want    string
+	}
+
+	tests := []precisionTest{
+		{
+			options: map[string]string{"precision": "2"},
+			want:    "ID,AMOUNT\n1,1234.56\n2,-0.12\n3,N/A\n4,\n",
+		},
+		{
+			options: map[string]string{"precision": "2", "rounding": "half-up", "on-invalid": "blank"},
+			want:    "ID,AMOUNT\n1,1234.57\n2,-0.13\n3,\n4,\n",
+		},
+		{
+			options: map[string]string{"precision": "0", "on-invalid": "flag"},
+			want:    "ID,AMOUNT,AMOUNT_valid\n1,1235,true\n2,0,true\n3,N/A,false\n4,,\n",
+		},
+		{
+			options: map[string]string{"on-invalid": "reject"},
+			want:    "ID,AMOUNT\n1,1234.565\n2,-0.125\n4,\n",
+		},
+	}
+
+	for _, tc := range tests {
+		flags := map[string]flagval{
+			"normalizeNumber": flagval{
+				active:  true,
+				value:   "en-AU",
+				options: tc.options,
+			},
+		}
+
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"AMOUNT"}, flags))
+		writer.Flush()
+
+		assert.Equal(t, tc.want, result.String(), tc.options)
+	}
+}
+
+func TestNormalizeNumberErrors(t *testing.T) {
+	for _, f := range []flagval{
+		{active: true, value: "xx"},
+		{active: true, value: "en", options: map[string]string{"precision": "two"}},
+		{active: true, value: "en", options: map[string]string{"rounding": "sideways"}},
+		{active: true, value: "en", options: map[string]string{"on-invalid": "explode"}},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.NotNil(t, gumption(strings.NewReader("one\n1"), *writer, []string{}, map[string]flagval{"normalizeNumber": f}))
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMask(t *testing.T) {
+	assert.Equal(t, "*****6789", mask("123456789", 4))
+	assert.Equal(t, "123", mask("123", 4))
+	assert.Equal(t, "****", mask("1234", 0))
+	assert.Equal(t, "**é", mask("aéé", 1))
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "Xxxx X'Xxxxx", redact("Jane O'Brien"))
+	assert.Equal(t, "999-999", redact("062-000"))
+	assert.Equal(t, "xxx@xxxxxxx.xxx.xx", redact("ada@example.com.au"))
+}
+
+func TestTokenise(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	keyPath := path.Join(dir, "key")
+	assert.Nil(t, ioutil.WriteFile(keyPath, []byte("secret\n"), 0600))
+
+	// echo -n 123456782 | openssl dgst -sha256 -hmac secret
+	token := "73e89fd22fe7409a215ceb7a71b1eee7c44f09b6f14cedfb7a3c6a4273236182"
+
+	input := `ID,TFN
+1,123456782
+2,
+3,123456782`
+
+	want := "ID,TFN\n1," + token + "\n2,\n3," + token + "\n"
+
+	flags := map[string]flagval{
+		"tokenise": flagval{
+			active:  true,
+			options: map[string]string{"key-file": keyPath},
+		},
+	}
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"TFN"}, flags))
+	writer.Flush()
+	assert.Equal(t, want, result.String())
+
+	os.Setenv(hmacKeyEnv, "secret")
+	defer os.Unsetenv(hmacKeyEnv)
+
+	flags = map[string]flagval{
+		"tokenise": flagval{
+			active: true,
+		},
+	}
+
+	result = strings.Builder{}
+	writer = csv.NewWriter(&result)
+
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"TFN"}, flags))
+	writer.Flush()
+	assert.Equal(t, want, result.String())
+}
+
+func TestTokeniseNeedsKey(t *testing.T) {
+	os.Unsetenv(hmacKeyEnv)
+
+	flags := map[string]flagval{
+		"tokenise": flagval{
+			active: true,
+		},
+	}
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.NotNil(t, gumption(strings.NewReader("ID\n1"), *writer, []string{}, flags))
+}
+
+func TestMaskAndNull(t *testing.T) {
+	recipe := `steps:
+  - op: mask
+    columns: [ACCOUNT]
+    value: "3"
+  - op: "null"
+    columns: [NAME]`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader("NAME,ACCOUNT\nAda,12345678"), *writer, operations, true, "", ""))
+	writer.Flush()
+	assert.Equal(t, "NAME,ACCOUNT\n,*****678\n", result.String())
+
+	result = strings.Builder{}
+	writer = csv.NewWriter(&result)
+
+	flags := map[string]flagval{
+		"mask": flagval{
+			active: true,
+			value:  "-1",
+		},
+	}
+
+	assert.NotNil(t, gumption(strings.NewReader("NAME\nAda"), *writer, []string{}, flags))
+}
@@ -0,0 +1,1157 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paidright/datalab/util"
+	"golang.org/x/text/unicode/norm"
+)
+
+var alphas = regexp.MustCompile("[a-zA-Z]+")
+
+// operation is a single gumption flag applied to its own set of columns. Runs
+// of gumption are made up of a list of operations applied to each row in turn.
+type operation struct {
+	name    string
+	columns []string
+	flag    flagval
+	// condition is an --if expression limiting the operation to the rows it
+	// is true for. guard is the parsed form of it.
+	condition string
+	guard     node
+
+	// outputs maps each target column to the column its result is written to
+	// for operations that add or rename columns
+	outputs map[string]string
+	rules   []regexRule
+	program program
+	// onInvalid is what --eval does with assignments it can't evaluate
+	onInvalid string
+	predicate node
+	tz        *tzConversion
+	dates     *dateReformat
+	numbers   *numberNormaliser
+	ids       *identifierCheck
+	duration  *durationCalc
+	key       []byte
+	namespace []byte
+	keep      int
+	form      norm.Form
+	mapping   *valueMap
+	fill      *filler
+	dedupe    *deduper
+	sequence  *sequence
+	asserts   *assertions
+	// targets holds the columns --split-into writes to
+	targets []string
+
+	// validity maps each target column to the column that records whether
+	// its cell could be read, for operations with --on-invalid flag
+	validity map[string]string
+
+	// counts tallies how each row came out for operations that report a
+	// summary at the end of the run
+	counts map[string]int
+	// failed counts the cells --on-invalid fail gave up on
+	failed int
+	// report collects the changes the operation made when --report is set
+	report *stepReport
+}
+
+// flush hands over any rows an operation is still holding on to at the end
+// of the input, a batch at a time until there are none left
+func (op *operation) flush() ([]util.Line, error) {
+	if op.fill != nil {
+		return op.fill.flush(), nil
+	}
+	if op.dedupe != nil {
+		return op.dedupe.flush(op)
+	}
+	return []util.Line{}, nil
+}
+
+// finish wraps up anything an operation has left to do once every row has
+// been through it
+func (op *operation) finish() error {
+	if op.failed > 0 {
+		return fmt.Errorf("%d cells could not be read", op.failed)
+	}
+	if op.mapping != nil {
+		return op.mapping.finish()
+	}
+	if op.asserts != nil {
+		return op.asserts.finish()
+	}
+	if op.dedupe != nil {
+		return op.dedupe.finish()
+	}
+	return nil
+}
+
+// option reads a companion setting, falling back to a default if it is unset
+func (op *operation) option(name string, fallback string) string {
+	if v, ok := op.flag.options[name]; ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+// invalidModes are the choices for --on-invalid
+var invalidModes = []string{"keep", "blank", "flag", "reject", "fail"}
+
+var invalidOutcomes = map[string]string{
+	"keep":   "kept",
+	"blank":  "blanked",
+	"flag":   "flagged",
+	"reject": "rejected",
+	"fail":   "failed",
+}
+
+// addValidity adds a <column>_valid column for each of columns
+func (op *operation) addValidity(headers []string, columns []string) []string {
+	op.validity = map[string]string{}
+	for _, col := range columns {
+		op.validity[col] = col + "_valid"
+		if !util.Contains(op.validity[col], headers) {
+			headers = append(headers, op.validity[col])
+		}
+	}
+	return headers
+}
+
+func (op *operation) valid(line util.Line, col string, valid bool) {
+	if name, ok := op.validity[col]; ok {
+		line.Data[name] = strconv.FormatBool(valid)
+	}
+}
+
+// invalid deals with a cell the operation couldn't make sense of according
+// to mode, returning the new cell and whether the row should be rejected
+func (op *operation) invalid(mode string, line util.Line, col string, cell string, problem string) (string, bool) {
+	op.count(problem + " " + invalidOutcomes[mode])
+
+	switch mode {
+	case "blank":
+		return "", false
+	case "flag":
+		op.valid(line, col, false)
+		return cell, false
+	case "reject":
+		log.Println("WARN rejecting row", line.Number, problem, col, cell)
+		return cell, true
+	case "fail":
+		op.failed++
+		op.warn("failing on", problem, col, cell)
+		return cell, false
+	}
+
+	op.warn("ignoring", problem, col, cell)
+	return cell, false
+}
+
+// warn logs a cell the operation couldn't make sense of. With --report the
+// count in the report takes the place of a line per cell.
+func (op *operation) warn(args ...interface{}) {
+	if op.report != nil {
+		return
+	}
+	log.Println(append([]interface{}{"WARN"}, args...)...)
+}
+
+func (op *operation) count(outcome string) {
+	if op.counts == nil {
+		op.counts = map[string]int{}
+	}
+	op.counts[outcome]++
+}
+
+// summary describes the counts for an operation eg: "12 converted, 1 dst gap"
+func (op *operation) summary() string {
+	outcomes := []string{}
+	for outcome := range op.counts {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Strings(outcomes)
+
+	parts := []string{}
+	for _, outcome := range outcomes {
+		parts = append(parts, fmt.Sprintf("%d %s", op.counts[outcome], outcome))
+	}
+	return strings.Join(parts, ", ")
+}
+
+type regexRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// parseRegexRules reads one PATTERN=>REPLACEMENT rule per line
+func parseRegexRules(input string) ([]regexRule, error) {
+	rules := []regexRule{}
+	for _, line := range strings.Split(input, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=>", 2)
+		if len(parts) != 2 {
+			return rules, fmt.Errorf("expected PATTERN=>REPLACEMENT but got %s", line)
+		}
+		pattern, err := regexp.Compile(parts[0])
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, regexRule{
+			pattern:     pattern,
+			replacement: parts[1],
+		})
+	}
+	return rules, nil
+}
+
+// pipeline holds everything needed to push rows through a list of operations
+type pipeline struct {
+	operations []*operation
+	// strict pipelines refuse to run if an operation targets a column that
+	// doesn't exist by the time the operation runs
+	strict  bool
+	rejects *csv.Writer
+	output  csv.Writer
+	headers []string
+	report  *report
+}
+
+// runOperations streams input through the operations and writes the result to
+// output. Rows dropped by --where are written to rejectsPath if it is set, and
+// a report of what each operation changed to reportPath.
+func runOperations(input io.Reader, output csv.Writer, operations []*operation, strict bool, rejectsPath string, reportPath string) error {
+	p := pipeline{
+		operations: operations,
+		strict:     strict,
+	}
+
+	if rejectsPath != "" {
+		f, err := os.Create(rejectsPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		p.rejects = csv.NewWriter(f)
+		defer p.rejects.Flush()
+	}
+
+	if reportPath != "" {
+		p.report = newReport(operations)
+	}
+
+	work, errors := util.ReadSourceAsync(input)
+
+	var cachedErr error
+	go (func() {
+		for err := range errors {
+			log.Println("ERROR", err)
+			cachedErr = err
+		}
+	})()
+
+	p.output = output
+
+	for line := range work {
+		if len(p.headers) == 0 {
+			headers, err := p.prepare(line.Headers)
+			if err != nil {
+				return fmt.Errorf("Error handling headers %w", err)
+			}
+			p.headers = headers
+			if err := output.Write(headers); err != nil {
+				return err
+			}
+			output.Flush()
+		}
+
+		if err := p.process([]util.Line{line}, 0); err != nil {
+			return err
+		}
+	}
+
+	// Operations that hold on to rows hand them over in order, so rows let go
+	// by one operation still pass through every operation after it
+	for i, op := range p.operations {
+		for {
+			flushed, err := op.flush()
+			if err != nil {
+				return err
+			}
+			if len(flushed) == 0 {
+				break
+			}
+			if op.report != nil {
+				op.report.emitted(flushed)
+			}
+			if err := p.process(flushed, i+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Every operation gets to finish, and report, before a failure is returned
+	var finishErr error
+	for i, op := range p.operations {
+		if len(op.counts) > 0 {
+			logger.Info(fmt.Sprintf("step %d (%s): %s", i+1, flagName(op.name), op.summary()))
+		}
+		if err := op.finish(); err != nil && finishErr == nil {
+			finishErr = err
+			if p.strict {
+				finishErr = fmt.Errorf("step %d (%s): %w", i+1, flagName(op.name), err)
+			}
+		}
+	}
+	if p.report != nil {
+		if err := p.report.write(reportPath); err != nil {
+			return err
+		}
+	}
+	if finishErr != nil {
+		return finishErr
+	}
+
+	return cachedErr
+}
+
+// process runs lines through the operations from the one numbered from
+// onwards and writes out whatever is left
+func (p *pipeline) process(lines []util.Line, from int) error {
+	for _, op := range p.operations[from:] {
+		next := []util.Line{}
+		for _, l := range lines {
+			if op.guard != nil && !op.matches(l) {
+				op.count("skipped by if")
+				if op.report != nil {
+					op.report.skipped()
+				}
+				next = append(next, l)
+				continue
+			}
+
+			if op.report != nil {
+				op.report.seen(l)
+			}
+			result, err := p.apply(op, l)
+			if err != nil {
+				return err
+			}
+			if op.report != nil {
+				op.report.done(l, result)
+			}
+			next = append(next, result...)
+		}
+		lines = next
+	}
+
+	for _, l := range lines {
+		newLine := []string{}
+		for _, header := range p.headers {
+			newLine = append(newLine, l.Data[header])
+		}
+		if err := p.output.Write(newLine); err != nil {
+			return err
+		}
+	}
+	p.output.Flush()
+
+	return p.output.Error()
+}
+
+// prepare works out the output headers by running the input headers through
+// each operation in turn
+func (p *pipeline) prepare(headers []string) ([]string, error) {
+	if os.Getenv("NO_STRIP_BOM") != "true" {
+		headers[0] = strings.TrimLeftFunc(headers[0], func(r rune) bool {
+			return r == '\uFEFF'
+		})
+	}
+
+	if p.rejects != nil {
+		if err := p.rejects.Write(append(append([]string{}, headers...), "gumption_reject_reason")); err != nil {
+			return []string{}, err
+		}
+	}
+
+	current := append([]string{}, headers...)
+
+	for i, op := range p.operations {
+		var err error
+		current, err = op.prepare(current, p.strict)
+		if err != nil {
+			if p.strict {
+				return []string{}, fmt.Errorf("step %d (%s): %w", i+1, flagName(op.name), err)
+			}
+			return []string{}, err
+		}
+	}
+
+	return current, nil
+}
+
+// rowOperations don't use --columns
+var rowOperations = []string{"eval", "where", "duration", "renameMap", "sequence"}
+
+// unconditionalOperations change the headers or hold rows back, so they
+// can't be limited to some rows with --if
+var unconditionalOperations = []string{
+	"rename",
+	"drop",
+	"cleanCols",
+	"renameMap",
+	"headerCase",
+	"headerAscii",
+	"headerMaxLength",
+	"fillUp",
+	"dedupe",
+}
+
+func (op *operation) prepare(headers []string, strict bool) ([]string, error) {
+	if op.condition != "" {
+		guard, err := parseExpression(op.condition)
+		if err != nil {
+			return headers, fmt.Errorf("Error parsing if %w", err)
+		}
+		for _, ref := range columnRefs(guard) {
+			if !util.Contains(ref, headers) {
+				return headers, fmt.Errorf("unknown column %s in if", ref)
+			}
+		}
+		op.guard = guard
+	}
+
+	// Every column would include ones made by earlier operations, such as
+	// --sequence, so IDs would change with the order of the rows
+	if op.name == "surrogateId" && len(op.columns) == 0 {
+		return headers, fmt.Errorf("surrogate id needs the columns to make the ID from")
+	}
+
+	if util.Contains(op.name, rowOperations) {
+		op.columns = []string{}
+	} else if len(op.columns) == 0 {
+		op.columns = append([]string{}, headers...)
+	} else if strict {
+		for _, col := range op.columns {
+			if !util.Contains(col, headers) {
+				return headers, fmt.Errorf("unknown column %s", col)
+			}
+		}
+	}
+
+	op.outputs = map[string]string{}
+
+	switch op.name {
+	case "leftPad":
+		parts := strings.Split(op.flag.value, ",")
+		if len(parts) != 2 {
+			return headers, fmt.Errorf("left pad expects a character and a width eg: 0,4")
+		}
+
+	case "reformatDate":
+		dates, err := newDateReformat(op)
+		if err != nil {
+			return headers, err
+		}
+		op.dates = dates
+		if dates.onInvalid == "flag" {
+			headers = op.addValidity(headers, op.columns)
+		}
+
+	case "reformatTime":
+		if len(strings.Split(op.flag.value, ",")) != 2 {
+			return headers, fmt.Errorf("expected an input and an output format eg: DD.MM.YYYY,YYYY-MM-DD")
+		}
+
+	case "rename":
+		if len(op.columns) > 1 {
+			return headers, fmt.Errorf("Can only rename one column at at a time")
+		}
+		if len(op.columns) == 0 {
+			return headers, fmt.Errorf("Cannot rename without setting a single target column")
+		}
+		for i, header := range headers {
+			if header == op.columns[0] {
+				headers[i] = op.flag.value
+			}
+		}
+		op.outputs[op.columns[0]] = op.flag.value
+
+	case "renameMap":
+		renames, err := parseRenameMap(op.flag.value)
+		if err != nil {
+			return headers, err
+		}
+		targets := []string{}
+		for from := range renames {
+			if util.Contains(from, headers) {
+				targets = append(targets, from)
+			} else if strict {
+				return headers, fmt.Errorf("unknown column %s", from)
+			}
+		}
+		return op.renameHeaders(headers, targets, func(header string) string {
+			return renames[header]
+		}, 0)
+
+	case "headerCase":
+		if !util.Contains(op.flag.value, headerCases) {
+			return headers, fmt.Errorf("header case must be one of %s", strings.Join(headerCases, ", "))
+		}
+		return op.renameHeaders(headers, op.columns, func(header string) string {
+			return convertCase(header, op.flag.value)
+		}, 0)
+
+	case "headerAscii":
+		return op.renameHeaders(headers, op.columns, stripNonASCII, 0)
+
+	case "headerMaxLength":
+		length, err := strconv.Atoi(op.flag.value)
+		if err != nil || length < 1 {
+			return headers, fmt.Errorf("header max length must be a whole number of characters")
+		}
+		return op.renameHeaders(headers, op.columns, func(header string) string {
+			return header
+		}, length)
+
+	case "regexReplace":
+		rules, err := parseRegexRules(op.flag.value)
+		if err != nil {
+			return headers, err
+		}
+		op.rules = rules
+
+	case "regexExtract":
+		if len(op.columns) != 1 {
+			return headers, fmt.Errorf("Can only extract from a single target column")
+		}
+		rules, err := parseRegexRules(op.flag.value)
+		if err != nil {
+			return headers, err
+		}
+		if len(rules) != 1 {
+			return headers, fmt.Errorf("expected a single PATTERN=>NEWCOL")
+		}
+		op.rules = rules
+		op.outputs[op.columns[0]] = rules[0].replacement
+		if !util.Contains(rules[0].replacement, headers) {
+			headers = append(headers, rules[0].replacement)
+		}
+
+	case "normalizeNumber":
+		numbers, err := newNumberNormaliser(op)
+		if err != nil {
+			return headers, err
+		}
+		op.numbers = numbers
+		if numbers.onInvalid == "flag" {
+			headers = op.addValidity(headers, op.columns)
+		}
+
+	case "tfn", "abn", "bsb", "bankAccount":
+		ids, err := newIdentifierCheck(op)
+		if err != nil {
+			return headers, err
+		}
+		op.ids = ids
+		if ids.onInvalid == "flag" {
+			headers = op.addValidity(headers, op.columns)
+		}
+
+	case "convertTz", "toUtc", "toEpoch":
+		tz, err := newTzConversion(op, headers)
+		if err != nil {
+			return headers, err
+		}
+		op.tz = tz
+
+	case "mapFile":
+		mapping, err := newValueMap(op)
+		if err != nil {
+			return headers, err
+		}
+		op.mapping = mapping
+
+	case "sequence":
+		sequence, err := newSequence(op, headers)
+		if err != nil {
+			return headers, err
+		}
+		op.sequence = sequence
+		if !util.Contains(sequence.target, headers) {
+			headers = append(headers, sequence.target)
+		}
+
+	case "surrogateId":
+		namespace, err := parseUUID(op.option("namespace", ""))
+		if err != nil {
+			return headers, err
+		}
+		op.namespace = namespace
+		if !util.Contains(op.flag.value, headers) {
+			headers = append(headers, op.flag.value)
+		}
+
+	case "fillDown", "fillUp":
+		fill, err := newFiller(op, headers)
+		if err != nil {
+			return headers, err
+		}
+		op.fill = fill
+
+	case "dedupe":
+		dedupe, err := newDeduper(op, headers)
+		if err != nil {
+			return headers, err
+		}
+		op.dedupe = dedupe
+
+	case "assert":
+		asserts, err := newAssertions(op)
+		if err != nil {
+			return headers, err
+		}
+		op.asserts = asserts
+		// Don't check the violations found by an earlier assert step
+		columns := []string{}
+		for _, col := range op.columns {
+			if col != violationsColumn {
+				columns = append(columns, col)
+			}
+		}
+		op.columns = columns
+		if asserts.mode == "flag" && !util.Contains(violationsColumn, headers) {
+			headers = append(headers, violationsColumn)
+		}
+
+	case "tokenise":
+		key, err := loadHMACKey(op)
+		if err != nil {
+			return headers, err
+		}
+		op.key = key
+
+	case "mask":
+		keep, err := parseMaskWidth(op.flag.value)
+		if err != nil {
+			return headers, err
+		}
+		op.keep = keep
+
+	case "normalizeUnicode":
+		form, err := parseUnicodeForm(op.flag.value)
+		if err != nil {
+			return headers, err
+		}
+		op.form = form
+
+	case "changeCase":
+		if !util.Contains(op.flag.value, textCases) {
+			return headers, fmt.Errorf("case must be one of %s", strings.Join(textCases, ", "))
+		}
+
+	case "truncate":
+		length, err := parseTruncateLength(op.flag.value)
+		if err != nil {
+			return headers, err
+		}
+		op.keep = length
+
+	case "splitInto":
+		if len(op.columns) != 1 {
+			return headers, fmt.Errorf("Can only split one column at a time")
+		}
+		op.targets = strings.Split(op.flag.value, ",")
+		for _, target := range op.targets {
+			if !util.Contains(target, headers) {
+				headers = append(headers, target)
+			}
+		}
+
+	case "explode":
+		if len(op.columns) != 1 {
+			return headers, fmt.Errorf("Can only explode one column at a time")
+		}
+		op.outputs[op.columns[0]] = op.option("index-column", op.columns[0]+"_index")
+		if !util.Contains(op.outputs[op.columns[0]], headers) {
+			headers = append(headers, op.outputs[op.columns[0]])
+		}
+
+	case "splitOnDelim", "cp":
+		for _, col := range op.columns {
+			op.outputs[col] = suffixed(col, headers, 1)
+			headers = append(headers, op.outputs[col])
+		}
+
+	case "drop":
+		newHeaders := []string{}
+		for _, header := range headers {
+			if !util.Contains(header, op.columns) {
+				newHeaders = append(newHeaders, header)
+			}
+		}
+		headers = newHeaders
+
+	case "cleanCols":
+		for i, header := range headers {
+			if util.Contains(header, op.columns) {
+				op.outputs[header] = cleanCol(header)
+				headers[i] = op.outputs[header]
+			}
+		}
+
+	case "duration":
+		duration, err := newDurationCalc(op, headers)
+		if err != nil {
+			return headers, err
+		}
+		op.duration = duration
+		if !util.Contains(duration.target, headers) {
+			headers = append(headers, duration.target)
+		}
+
+	case "eval":
+		prog, err := parseProgram(op.flag.value)
+		if err != nil {
+			return headers, fmt.Errorf("Error parsing eval %w", err)
+		}
+		if err := prog.validate(headers); err != nil {
+			return headers, err
+		}
+		for _, target := range prog.targets() {
+			if !util.Contains(target, headers) {
+				headers = append(headers, target)
+			}
+		}
+		op.program = prog
+
+		op.onInvalid = op.option("on-invalid", "keep")
+		if !util.Contains(op.onInvalid, invalidModes) {
+			return headers, fmt.Errorf("on-invalid must be one of %s", strings.Join(invalidModes, ", "))
+		}
+		if op.onInvalid == "flag" {
+			headers = op.addValidity(headers, prog.targets())
+		}
+
+	case "where":
+		predicate, err := parseExpression(op.flag.value)
+		if err != nil {
+			return headers, fmt.Errorf("Error parsing where %w", err)
+		}
+		for _, ref := range columnRefs(predicate) {
+			if !util.Contains(ref, headers) {
+				return headers, fmt.Errorf("unknown column %s in where", ref)
+			}
+		}
+		op.predicate = predicate
+	}
+
+	return headers, nil
+}
+
+const defaultDelimiter = ";"
+
+// explode turns a line into one line per delimited element of col, numbering
+// each element in index
+func explode(line util.Line, col string, index string, delimiter string) []util.Line {
+	lines := []util.Line{}
+	for i, element := range strings.Split(line.Data[col], delimiter) {
+		exploded := line
+		exploded.Data = map[string]string{}
+		for k, v := range line.Data {
+			exploded.Data[k] = v
+		}
+		exploded.Data[col] = element
+		exploded.Data[index] = strconv.Itoa(i + 1)
+		lines = append(lines, exploded)
+	}
+	return lines
+}
+
+// matches reports whether a line passes the operation's --if condition
+func (op *operation) matches(line util.Line) bool {
+	v, err := op.guard.eval(line.Data)
+	if err != nil {
+		log.Println("WARN skipping garbled row", line.Number, err)
+		return false
+	}
+	matched, err := v.asBool()
+	if err != nil {
+		log.Println("WARN skipping garbled row", line.Number, err)
+		return false
+	}
+	return matched
+}
+
+// reject records that the row currently being processed was dropped
+func (p *pipeline) reject(line util.Line, reason string) error {
+	if p.rejects == nil {
+		return nil
+	}
+	return p.rejects.Write(append(append([]string{}, line.Record...), reason))
+}
+
+// apply runs a single operation over a line, returning the lines that should
+// carry on down the pipeline
+func (p *pipeline) apply(op *operation, line util.Line) ([]util.Line, error) {
+	switch op.name {
+	case "eval":
+		for _, target := range op.program.targets() {
+			op.valid(line, target, true)
+		}
+		rejected := false
+		op.program.run(line.Data, func(target string, err error) {
+			op.warn("garbled row", line.Number, target+":", err)
+			var reject bool
+			line.Data[target], reject = op.invalid(op.onInvalid, line, target, line.Data[target], "garbled expression")
+			rejected = rejected || reject
+		})
+		if rejected {
+			return []util.Line{}, p.reject(line, "garbled expression")
+		}
+		return []util.Line{line}, nil
+
+	case "duration":
+		result, outcome := op.duration.calculate(line.Data)
+		op.count(outcome)
+		if result == "" && outcome != "blank" {
+			op.warn("ignoring garbled row", line.Number, outcome)
+		}
+		line.Data[op.duration.target] = result
+		return []util.Line{line}, nil
+
+	case "renameMap", "headerCase", "headerAscii", "headerMaxLength":
+		op.rekey(line)
+		return []util.Line{line}, nil
+
+	case "sequence":
+		line.Data[op.sequence.target] = op.sequence.number(line)
+		return []util.Line{line}, nil
+
+	case "surrogateId":
+		line.Data[op.flag.value] = surrogateID(op.namespace, op.columns, line)
+		return []util.Line{line}, nil
+
+	case "fillDown":
+		op.fill.down(op, line)
+		return []util.Line{line}, nil
+
+	case "fillUp":
+		return op.fill.up(op, line), nil
+
+	case "dedupe":
+		if op.dedupe.policy == "last" {
+			return op.dedupe.last(line)
+		}
+		return op.dedupe.first(op, line)
+
+	case "assert":
+		found := op.asserts.violations(op.columns, line)
+		if len(found) == 0 {
+			op.count("passed")
+			return []util.Line{line}, nil
+		}
+		for _, violation := range found {
+			op.count("failed " + violation)
+		}
+		op.asserts.broken++
+		reason := strings.Join(found, "; ")
+
+		switch op.asserts.mode {
+		case "flag":
+			if line.Data[violationsColumn] != "" {
+				reason = line.Data[violationsColumn] + "; " + reason
+			}
+			line.Data[violationsColumn] = reason
+		case "reject":
+			log.Println("WARN rejecting row", line.Number, "failed", reason)
+			return []util.Line{}, p.reject(line, "failed "+reason)
+		default:
+			log.Println("WARN row", line.Number, "failed", reason)
+		}
+		return []util.Line{line}, nil
+
+	case "where":
+		keep, reason := false, "where"
+		v, err := op.predicate.eval(line.Data)
+		if err == nil {
+			keep, err = v.asBool()
+		}
+		if err != nil {
+			log.Println("WARN dropping garbled row", line.Number, err)
+			reason = err.Error()
+		}
+		if !keep {
+			return []util.Line{}, p.reject(line, reason)
+		}
+		return []util.Line{line}, nil
+	}
+
+	if op.name == "explode" {
+		return explode(line, op.columns[0], op.outputs[op.columns[0]], op.option("delimiter", defaultDelimiter)), nil
+	}
+
+	for _, col := range op.columns {
+		cell := line.Data[col]
+
+		switch op.name {
+		case "stripLeadingZeroes":
+			cell = strings.TrimLeft(cell, "0")
+
+		case "leftPad":
+			parts := strings.Split(op.flag.value, ",")
+			pad := parts[0]
+			length, err := strconv.Atoi(parts[1])
+
+			if err != nil {
+				op.warn("ignoring garbled cell", col, line.Data[col])
+			} else {
+				for i := len(cell); i < length; i++ {
+					cell = pad + cell
+				}
+			}
+
+		case "unquote":
+			cell = strings.Trim(cell, `"`)
+			cell = strings.Trim(cell, `'`)
+
+		case "commasToPoints":
+			cell = strings.ReplaceAll(cell, ",", ".")
+
+		case "addMissing":
+			if cell == "" {
+				cell = op.flag.value
+			}
+
+		case "replaceCell":
+			for _, rep := range op.flag.replacements {
+				if cell == rep.from {
+					cell = rep.to
+				}
+			}
+
+		case "replaceCellLookup":
+			for _, rep := range op.flag.replacements {
+				if cell == rep.from {
+					cell = line.Data[rep.to]
+				}
+			}
+
+		case "replaceChar":
+			for _, rep := range op.flag.replacements {
+				cell = strings.ReplaceAll(cell, rep.from, rep.to)
+			}
+
+		case "regexReplace":
+			for _, rule := range op.rules {
+				cell = rule.pattern.ReplaceAllString(cell, rule.replacement)
+			}
+
+		case "regexExtract":
+			match := op.rules[0].pattern.FindStringSubmatch(cell)
+			extracted := ""
+			if len(match) > 1 {
+				extracted = match[1]
+			} else if len(match) == 1 {
+				extracted = match[0]
+			}
+			line.Data[op.outputs[col]] = extracted
+
+		case "mapFile":
+			mapped, outcome := op.mapping.lookup(cell)
+			op.count(outcome)
+			cell = mapped
+
+		case "stompAlphas":
+			cell = alphas.ReplaceAllString(cell, "")
+
+		case "rename", "cp", "cleanCols":
+			line.Data[op.outputs[col]] = cell
+
+		case "splitOnDelim":
+			parts := strings.SplitN(cell, op.flag.value, 2)
+			if len(parts) > 1 {
+				cell = parts[0]
+				line.Data[op.outputs[col]] = parts[1]
+			}
+
+		case "splitInto":
+			parts := strings.SplitN(cell, op.option("delimiter", defaultDelimiter), len(op.targets))
+			for i, target := range op.targets {
+				if i < len(parts) {
+					line.Data[target] = parts[i]
+				} else {
+					line.Data[target] = op.option("pad", "")
+				}
+			}
+			// The source column keeps its value unless it is also a target
+			if util.Contains(col, op.targets) {
+				continue
+			}
+
+		case "deleteWhere":
+			if cell == op.flag.value {
+				return []util.Line{}, nil
+			}
+
+		case "deleteWhereNot":
+			if cell != op.flag.value {
+				return []util.Line{}, nil
+			}
+
+		case "trimWhitespace":
+			cell = strings.Trim(cell, " ")
+
+		case "removeNonPrintable":
+			cell = removeNonPrintable(cell)
+
+		case "normalizeUnicode":
+			cell = op.form.String(cell)
+
+		case "stripAccents":
+			cell = stripAccents(cell)
+
+		case "straightenQuotes":
+			cell = straightenQuotes(cell)
+
+		case "collapseWhitespace":
+			cell = collapseWhitespace(cell)
+
+		case "changeCase":
+			cell = changeCase(cell, op.flag.value)
+
+		case "truncate":
+			cell = truncate(cell, op.keep)
+
+		case "backToFront":
+			i := len(cell) - 1
+			if i >= 0 && cell[i:] == op.flag.value {
+				cell = op.flag.value + cell[:i]
+			}
+
+		case "reformatDate":
+			if strings.TrimSpace(cell) == "" {
+				op.count("blank")
+				break
+			}
+
+			t, outcome, ok := op.dates.parse(cell)
+			if ok {
+				op.count(outcome)
+				op.valid(line, col, true)
+				cell = t.Format(op.dates.output)
+				break
+			}
+
+			// --date-ambiguity reject drops the row whatever --on-invalid says
+			mode := op.dates.onInvalid
+			if outcome == "ambiguous" {
+				mode = "reject"
+			}
+
+			var rejected bool
+			cell, rejected = op.invalid(mode, line, col, cell, outcome+" date")
+			if rejected {
+				return []util.Line{}, p.reject(line, outcome+" date")
+			}
+
+		case "normalizeNumber":
+			if strings.TrimSpace(cell) == "" {
+				op.count("blank")
+				break
+			}
+
+			n, ok := op.numbers.parse(cell)
+			if ok {
+				op.count("normalised")
+				op.valid(line, col, true)
+				cell = op.numbers.format(n)
+				break
+			}
+
+			var rejected bool
+			cell, rejected = op.invalid(op.numbers.onInvalid, line, col, cell, "invalid number")
+			if rejected {
+				return []util.Line{}, p.reject(line, "invalid number")
+			}
+
+		case "tfn", "abn", "bsb", "bankAccount":
+			if strings.TrimSpace(cell) == "" {
+				op.count("blank")
+				break
+			}
+
+			checked, ok := op.ids.check(cell)
+			if ok {
+				op.count("valid")
+				op.valid(line, col, true)
+				cell = checked
+				break
+			}
+
+			problem := "invalid " + op.ids.name
+			var rejected bool
+			cell, rejected = op.invalid(op.ids.onInvalid, line, col, cell, problem)
+			if rejected {
+				return []util.Line{}, p.reject(line, problem)
+			}
+
+		case "convertTz", "toUtc", "toEpoch":
+			converted, outcome, rejection := op.tz.convert(cell, line.Data)
+			op.count(outcome)
+			if rejection != "" {
+				log.Println("WARN rejecting row", line.Number, rejection, col, cell)
+				return []util.Line{}, p.reject(line, rejection)
+			}
+			if outcome == "unparseable" || outcome == "unknown timezone" {
+				op.warn("ignoring", outcome, col, cell)
+			}
+			cell = converted
+
+		case "tokenise":
+			cell = hmacToken(op.key, cell)
+
+		case "mask":
+			cell = mask(cell, op.keep)
+
+		case "redact":
+			cell = redact(cell)
+
+		case "null":
+			cell = ""
+
+		case "reformatTime":
+			format := strings.ReplaceAll(op.flag.value, "HH", "15")
+			format = strings.ReplaceAll(format, "MM", "04")
+			format = strings.ReplaceAll(format, "SS", "05")
+
+			inputLayout := strings.Split(format, ",")[0]
+			outputLayout := strings.Split(format, ",")[1]
+
+			t, err := time.Parse(inputLayout, cell)
+			if err != nil {
+				op.count("garbled time")
+				op.warn("ignoring garbled time", col, cell)
+			} else {
+				op.count("reformatted")
+				cell = t.Format(outputLayout)
+			}
+		}
+
+		line.Data[col] = cell
+	}
+
+	return []util.Line{line}, nil
+}
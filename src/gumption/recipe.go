@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/paidright/datalab/util"
+	"gopkg.in/yaml.v2"
+)
+
+// operationOrder is the order operations run in when they are given as flags
+// rather than as a recipe
+var operationOrder = []string{
+	"stripLeadingZeroes",
+	"leftPad",
+	"unquote",
+	"commasToPoints",
+	"fillDown",
+	"fillUp",
+	"addMissing",
+	"replaceCell",
+	"replaceCellLookup",
+	"replaceChar",
+	"regexReplace",
+	"mapFile",
+	"stompAlphas",
+	"rename",
+	"splitOnDelim",
+	"splitInto",
+	"explode",
+	"regexExtract",
+	"cp",
+	"deleteWhere",
+	"deleteWhereNot",
+	"trimWhitespace",
+	"removeNonPrintable",
+	"normalizeUnicode",
+	"stripAccents",
+	"straightenQuotes",
+	"collapseWhitespace",
+	"changeCase",
+	"truncate",
+	"backToFront",
+	"reformatDate",
+	"reformatTime",
+	"normalizeNumber",
+	"tfn",
+	"abn",
+	"bsb",
+	"bankAccount",
+	"convertTz",
+	"toUtc",
+	"toEpoch",
+	"duration",
+	"tokenise",
+	"mask",
+	"redact",
+	"null",
+	"drop",
+	"cleanCols",
+	"renameMap",
+	"headerCase",
+	"headerAscii",
+	"headerMaxLength",
+	"eval",
+	"where",
+	"dedupe",
+	"sequence",
+	"surrogateId",
+	"assert",
+}
+
+// operationNames maps the flag names used on the command line and in recipes
+// to the names used internally
+var operationNames = map[string]string{
+	"strip-leading-zeroes": "stripLeadingZeroes",
+	"left-pad":             "leftPad",
+	"unquote":              "unquote",
+	"commas-to-points":     "commasToPoints",
+	"fill-down":            "fillDown",
+	"fill-up":              "fillUp",
+	"add-missing":          "addMissing",
+	"replace-cell":         "replaceCell",
+	"replace-cell-lookup":  "replaceCellLookup",
+	"replace-char":         "replaceChar",
+	"regex-replace":        "regexReplace",
+	"regex-extract":        "regexExtract",
+	"map-file":             "mapFile",
+	"stomp-alphas":         "stompAlphas",
+	"rename":               "rename",
+	"split":                "splitOnDelim",
+	"split-into":           "splitInto",
+	"explode":              "explode",
+	"copy":                 "cp",
+	"delete-where":         "deleteWhere",
+	"delete-where-not":     "deleteWhereNot",
+	"trim-whitespace":      "trimWhitespace",
+	"remove-non-printable": "removeNonPrintable",
+	"normalize-unicode":    "normalizeUnicode",
+	"strip-accents":        "stripAccents",
+	"straighten-quotes":    "straightenQuotes",
+	"collapse-whitespace":  "collapseWhitespace",
+	"case":                 "changeCase",
+	"truncate":             "truncate",
+	"back-to-front":        "backToFront",
+	"reformat-date":        "reformatDate",
+	"reformat-time":        "reformatTime",
+	"normalize-number":     "normalizeNumber",
+	"tfn":                  "tfn",
+	"abn":                  "abn",
+	"bsb":                  "bsb",
+	"bank-account":         "bankAccount",
+	"convert-tz":           "convertTz",
+	"to-utc":               "toUtc",
+	"to-epoch":             "toEpoch",
+	"duration":             "duration",
+	"tokenise":             "tokenise",
+	"mask":                 "mask",
+	"redact":               "redact",
+	"null":                 "null",
+	"drop":                 "drop",
+	"clean-cols":           "cleanCols",
+	"rename-map":           "renameMap",
+	"header-case":          "headerCase",
+	"header-ascii":         "headerAscii",
+	"header-max-length":    "headerMaxLength",
+	"eval":                 "eval",
+	"where":                "where",
+	"dedupe":               "dedupe",
+	"sequence":             "sequence",
+	"surrogate-id":         "surrogateId",
+	"assert":               "assert",
+}
+
+// flagName finds the command line name for an operation
+func flagName(name string) string {
+	for flag, internal := range operationNames {
+		if internal == name {
+			return flag
+		}
+	}
+	return name
+}
+
+// operationOptions lists the companion settings each operation accepts in the
+// options of a recipe step
+var operationOptions = map[string][]string{
+	"reformatDate":    {"ambiguity", "on-invalid"},
+	"normalizeNumber": {"precision", "rounding", "on-invalid"},
+	"tfn":             {"on-invalid"},
+	"abn":             {"on-invalid"},
+	"bsb":             {"on-invalid"},
+	"bankAccount":     {"on-invalid"},
+	"eval":            {"on-invalid"},
+	"convertTz":       {"layout", "dst-overlap", "dst-gap"},
+	"toUtc":           {"layout", "dst-overlap", "dst-gap"},
+	"toEpoch":         {"layout", "dst-overlap", "dst-gap"},
+	"duration":        {"unit", "layout", "break-column", "precision"},
+	"tokenise":        {"key-file"},
+	"mapFile":         {"from", "to", "default", "ignore-case", "unmapped-report"},
+	"splitInto":       {"delimiter", "pad"},
+	"explode":         {"delimiter", "index-column"},
+	"fillDown":        {"group"},
+	"fillUp":          {"group"},
+	"assert":          {"on-violation"},
+	"sequence":        {"group"},
+	"dedupe":          {"duplicates", "max-keys"},
+	"surrogateId":     {"namespace"},
+}
+
+// switchOperations are boolean flags that don't take a value
+var switchOperations = []string{
+	"stripLeadingZeroes",
+	"unquote",
+	"commasToPoints",
+	"fillDown",
+	"stompAlphas",
+	"cp",
+	"drop",
+	"trimWhitespace",
+	"removeNonPrintable",
+	"stripAccents",
+	"straightenQuotes",
+	"collapseWhitespace",
+	"cleanCols",
+	"headerAscii",
+	"tokenise",
+	"redact",
+	"null",
+	"explode",
+	"tfn",
+	"abn",
+	"bsb",
+	"bankAccount",
+}
+
+// replacementOperations take a list of X,Y pairs
+var replacementOperations = []string{
+	"replaceCell",
+	"replaceCellLookup",
+	"replaceChar",
+}
+
+// errUnconditional is returned when --if is given to an operation that has to
+// apply to every row
+var errUnconditional = fmt.Errorf("applies to every row and cannot be limited with if")
+
+// flagOperations turns the flags given on the command line into a list of
+// operations in the classic fixed order, all sharing the same columns
+func flagOperations(columns []string, flags map[string]flagval) ([]*operation, error) {
+	operations := []*operation{}
+
+	for _, name := range operationOrder {
+		f, ok := flags[name]
+		if !ok || !f.active {
+			continue
+		}
+		op := &operation{
+			name:    name,
+			columns: append([]string{}, columns...),
+			flag:    f,
+		}
+		// --if limits every operation, so fail rather than quietly apply one
+		// that can't be limited to every row
+		if flags["if"].value != "" {
+			if util.Contains(name, unconditionalOperations) {
+				return operations, fmt.Errorf("--%s %w", flagName(name), errUnconditional)
+			}
+			op.condition = flags["if"].value
+		}
+		operations = append(operations, op)
+		// Everything after a rename should see the column by its new name
+		if name == "rename" && len(columns) == 1 {
+			columns = []string{f.value}
+		}
+	}
+
+	return operations, nil
+}
+
+type recipeStep struct {
+	Op      string            `yaml:"op"`
+	Columns []string          `yaml:"columns"`
+	Value   string            `yaml:"value"`
+	Options map[string]string `yaml:"options"`
+	If      string            `yaml:"if"`
+}
+
+type recipe struct {
+	Steps []recipeStep `yaml:"steps"`
+}
+
+// loadRecipe reads a YAML list of steps into operations. Anything that can be
+// checked without seeing the data is checked here.
+func loadRecipe(input io.Reader) ([]*operation, error) {
+	raw, err := ioutil.ReadAll(input)
+	if err != nil {
+		return []*operation{}, err
+	}
+
+	r := recipe{}
+	if err := yaml.UnmarshalStrict(raw, &r); err != nil {
+		return []*operation{}, fmt.Errorf("Error parsing recipe %w", err)
+	}
+
+	if len(r.Steps) == 0 {
+		return []*operation{}, fmt.Errorf("recipe has no steps")
+	}
+
+	operations := []*operation{}
+	for i, step := range r.Steps {
+		op, err := step.operation()
+		if err != nil {
+			return []*operation{}, fmt.Errorf("step %d (%s): %w", i+1, step.Op, err)
+		}
+		operations = append(operations, op)
+	}
+
+	return operations, nil
+}
+
+func (step recipeStep) operation() (*operation, error) {
+	name, ok := operationNames[step.Op]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation")
+	}
+
+	switch {
+	case util.Contains(name, switchOperations) && step.Value != "":
+		return nil, fmt.Errorf("does not take a value")
+	case !util.Contains(name, switchOperations) && step.Value == "":
+		return nil, fmt.Errorf("needs a value")
+	case util.Contains(name, rowOperations) && len(step.Columns) > 0:
+		return nil, fmt.Errorf("works on whole rows and does not take columns")
+	}
+
+	for option := range step.Options {
+		if !util.Contains(option, operationOptions[name]) {
+			return nil, fmt.Errorf("unknown option %s", option)
+		}
+	}
+
+	f := newFlagval(name, step.Value)
+	f.options = step.Options
+
+	if step.If != "" && util.Contains(name, unconditionalOperations) {
+		return nil, errUnconditional
+	}
+
+	return &operation{
+		name:      name,
+		columns:   step.Columns,
+		flag:      f,
+		condition: step.If,
+	}, nil
+}
+
+// newFlagval builds the settings for an operation the same way main does for
+// command line flags
+func newFlagval(name string, value string) flagval {
+	f := flagval{
+		active: true,
+		value:  value,
+	}
+	if util.Contains(name, replacementOperations) {
+		f.replacements = parseReplacements(value)
+	}
+	return f
+}
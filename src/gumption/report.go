@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/paidright/datalab/util"
+)
+
+// reportSamples is how many before and after values a report keeps for each
+// column
+const reportSamples = 3
+
+// rowCountedOperations change column names or drop whole rows rather than
+// change cells, so a report only counts the rows that go through them
+var rowCountedOperations = []string{"rename", "cleanCols", "renameMap", "headerCase", "headerAscii", "headerMaxLength", "dedupe"}
+
+// report records what each operation did to the rows going through it, for
+// --report
+type report struct {
+	Steps []*stepReport `json:"steps"`
+}
+
+type stepReport struct {
+	Step        int                      `json:"step"`
+	Operation   string                   `json:"operation"`
+	RowsIn      int                      `json:"rows_in"`
+	RowsOut     int                      `json:"rows_out"`
+	RowsDeleted int                      `json:"rows_deleted"`
+	RowsAdded   int                      `json:"rows_added"`
+	Outcomes    map[string]int           `json:"outcomes,omitempty"`
+	Columns     map[string]*columnReport `json:"columns,omitempty"`
+
+	op *operation
+	// examined counts the rows the operation actually worked on, leaving out
+	// those skipped by --if
+	examined int
+	// before holds a copy of each row the operation is still working on, so
+	// rows held back by --fill-up can be compared once they are let go
+	before map[int]map[string]string
+}
+
+type columnReport struct {
+	Examined int      `json:"examined"`
+	Changed  int      `json:"changed"`
+	Samples  []change `json:"samples,omitempty"`
+}
+
+type change struct {
+	Row    int    `json:"row"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+func newReport(operations []*operation) *report {
+	r := report{}
+	for i, op := range operations {
+		op.report = &stepReport{
+			Step:      i + 1,
+			Operation: flagName(op.name),
+			Columns:   map[string]*columnReport{},
+			op:        op,
+			before:    map[int]map[string]string{},
+		}
+		r.Steps = append(r.Steps, op.report)
+	}
+	return &r
+}
+
+func (s *stepReport) column(col string) *columnReport {
+	if _, ok := s.Columns[col]; !ok {
+		s.Columns[col] = &columnReport{}
+	}
+	return s.Columns[col]
+}
+
+// skipped counts a row the operation let through untouched
+func (s *stepReport) skipped() {
+	s.RowsIn++
+	s.RowsOut++
+}
+
+// seen takes a copy of a row before the operation gets to it
+func (s *stepReport) seen(line util.Line) {
+	s.RowsIn++
+	if util.Contains(s.op.name, rowCountedOperations) {
+		return
+	}
+
+	s.examined++
+	for _, col := range s.op.columns {
+		s.column(col).Examined++
+	}
+
+	before := map[string]string{}
+	for k, v := range line.Data {
+		before[k] = v
+	}
+	s.before[line.Number] = before
+}
+
+// done compares the rows an operation handed back for a line with how they
+// looked going in
+func (s *stepReport) done(line util.Line, result []util.Line) {
+	if len(result) == 0 && s.op.name != "fillUp" {
+		delete(s.before, line.Number)
+	}
+	s.emitted(result)
+}
+
+// emitted compares rows leaving the operation with the copies taken by seen
+func (s *stepReport) emitted(lines []util.Line) {
+	s.RowsOut += len(lines)
+
+	for _, line := range lines {
+		before, ok := s.before[line.Number]
+		if !ok {
+			continue
+		}
+		for col, after := range line.Data {
+			if before[col] == after {
+				continue
+			}
+			c := s.column(col)
+			c.Changed++
+			if len(c.Samples) < reportSamples {
+				c.Samples = append(c.Samples, change{Row: line.Number, Before: before[col], After: after})
+			}
+		}
+	}
+
+	for _, line := range lines {
+		delete(s.before, line.Number)
+	}
+}
+
+// finish fills in the totals once every row has been through
+func (s *stepReport) finish() {
+	s.Outcomes = s.op.counts
+	if s.RowsIn > s.RowsOut {
+		s.RowsDeleted = s.RowsIn - s.RowsOut
+	} else {
+		s.RowsAdded = s.RowsOut - s.RowsIn
+	}
+
+	// Columns the operation wrote to without being pointed at, like the
+	// target of --eval, were looked at on every row it worked on
+	for col, c := range s.Columns {
+		if !util.Contains(col, s.op.columns) {
+			c.Examined = s.examined
+		}
+	}
+}
+
+// write saves the report as JSON to path, or describes it on stderr if path
+// is "stderr"
+func (r *report) write(path string) error {
+	for _, step := range r.Steps {
+		step.finish()
+	}
+
+	if path == "stderr" {
+		r.describe(os.Stderr)
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// describe writes the report out for people rather than programs
+func (r *report) describe(w io.Writer) {
+	for _, step := range r.Steps {
+		fmt.Fprintf(w, "step %d (%s): %d rows in, %d out, %d deleted, %d added\n",
+			step.Step, step.Operation, step.RowsIn, step.RowsOut, step.RowsDeleted, step.RowsAdded)
+
+		if len(step.Outcomes) > 0 {
+			fmt.Fprintf(w, "  outcomes: %s\n", step.op.summary())
+		}
+
+		cols := []string{}
+		for col := range step.Columns {
+			cols = append(cols, col)
+		}
+		sort.Strings(cols)
+
+		for _, col := range cols {
+			c := step.Columns[col]
+			samples := []string{}
+			for _, sample := range c.Samples {
+				samples = append(samples, fmt.Sprintf("row %d %q => %q", sample.Row, sample.Before, sample.After))
+			}
+			fmt.Fprintf(w, "  %s: %d examined, %d changed", col, c.Examined, c.Changed)
+			if len(samples) > 0 {
+				fmt.Fprintf(w, " eg: %s", strings.Join(samples, ", "))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	recipe := `steps:
+  - op: strip-leading-zeroes
+    columns: [ID]
+  - op: reformat-time
+    columns: [START]
+    value: HH:MM,HHMM
+  - op: where
+    value: ID != "13"
+  - op: eval
+    value: LONG = HOURS > 8
+    if: HOURS is not empty
+  - op: fill-up
+    columns: [HOURS]
+    value: "1"`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	input := `ID,START,HOURS
+0012,09:00,
+0013,9am,4
+14,10:30,9`
+
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	reportPath := path.Join(dir, "report.json")
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader(input), *writer, operations, true, "", reportPath))
+	writer.Flush()
+
+	assert.Equal(t, `ID,START,HOURS,LONG
+12,0900,9,
+14,1030,9,true
+`, result.String())
+
+	raw, err := ioutil.ReadFile(reportPath)
+	assert.Nil(t, err)
+
+	r := report{}
+	assert.Nil(t, json.Unmarshal(raw, &r))
+	assert.Equal(t, 5, len(r.Steps))
+
+	strip := r.Steps[0]
+	assert.Equal(t, "strip-leading-zeroes", strip.Operation)
+	assert.Equal(t, 3, strip.RowsIn)
+	assert.Equal(t, 3, strip.Columns["ID"].Examined)
+	assert.Equal(t, 2, strip.Columns["ID"].Changed)
+	assert.Equal(t, []change{{2, "0012", "12"}, {3, "0013", "13"}}, strip.Columns["ID"].Samples)
+
+	// The garbled time is counted rather than logged
+	times := r.Steps[1]
+	assert.Equal(t, 1, times.Outcomes["garbled time"])
+	assert.Equal(t, 2, times.Columns["START"].Changed)
+
+	where := r.Steps[2]
+	assert.Equal(t, 3, where.RowsIn)
+	assert.Equal(t, 1, where.RowsDeleted)
+	assert.Equal(t, 0, len(where.Columns))
+
+	eval := r.Steps[3]
+	assert.Equal(t, 1, eval.Outcomes["skipped by if"])
+	assert.Equal(t, 1, eval.Columns["LONG"].Examined)
+	assert.Equal(t, []change{{4, "", "true"}}, eval.Columns["LONG"].Samples)
+
+	// Rows held back by fill-up are compared once they are let go
+	fill := r.Steps[4]
+	assert.Equal(t, 2, fill.RowsOut)
+	assert.Equal(t, 0, fill.RowsDeleted)
+	assert.Equal(t, []change{{2, "", "9"}}, fill.Columns["HOURS"].Samples)
+}
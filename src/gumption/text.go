@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+var unicodeForms = map[string]norm.Form{
+	"NFC":  norm.NFC,
+	"NFD":  norm.NFD,
+	"NFKC": norm.NFKC,
+	"NFKD": norm.NFKD,
+}
+
+var textCases = []string{"upper", "lower", "title"}
+
+func parseUnicodeForm(value string) (norm.Form, error) {
+	form, ok := unicodeForms[strings.ToUpper(value)]
+	if !ok {
+		return form, fmt.Errorf("unicode normalisation form must be one of NFC, NFD, NFKC or NFKD")
+	}
+	return form, nil
+}
+
+// stripAccents takes the marks off letters, eg: é becomes e. Letters that
+// aren't a base letter plus a mark, such as ø or ß, are left alone.
+func stripAccents(cell string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	result, _, err := transform.String(t, cell)
+	if err != nil {
+		return cell
+	}
+	return result
+}
+
+var quotes = strings.NewReplacer(
+	"‘", "'", "’", "'", "‚", "'", "‛", "'", "′", "'",
+	"“", `"`, "”", `"`, "„", `"`, "‟", `"`, "″", `"`,
+)
+
+// straightenQuotes swaps curly quotes, the kind word processors put in, for
+// plain ones
+func straightenQuotes(cell string) string {
+	return quotes.Replace(cell)
+}
+
+// collapseWhitespace turns each run of whitespace of any kind, including
+// non-breaking spaces and line breaks, into a single space and trims the ends
+func collapseWhitespace(cell string) string {
+	return strings.Join(strings.FieldsFunc(cell, unicode.IsSpace), " ")
+}
+
+// removeNonPrintable drops control and formatting characters such as zero
+// width spaces and byte order marks. Whitespace is left for
+// collapseWhitespace to deal with.
+func removeNonPrintable(cell string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return r
+		}
+		if unicode.In(r, unicode.Cc, unicode.Cf, unicode.Co, unicode.Cs) {
+			return -1
+		}
+		return r
+	}, cell)
+}
+
+func changeCase(cell string, textCase string) string {
+	switch textCase {
+	case "upper":
+		return cases.Upper(language.Und).String(cell)
+	case "lower":
+		return cases.Lower(language.Und).String(cell)
+	}
+	return cases.Title(language.Und).String(cell)
+}
+
+func parseTruncateLength(value string) (int, error) {
+	length, err := strconv.Atoi(value)
+	if err != nil || length < 0 {
+		return 0, fmt.Errorf("truncate expects the number of characters to keep eg: 30")
+	}
+	return length, nil
+}
+
+// truncate cuts a cell down to at most length characters, counting each
+// unicode code point as one
+func truncate(cell string, length int) string {
+	if r := []rune(cell); len(r) > length {
+		return string(r[:length])
+	}
+	return cell
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextCleanup(t *testing.T) {
+	assert.Equal(t, "Zoe Bjørk Muller", stripAccents("Zoë Bjørk Müller"))
+	assert.Equal(t, "Jose", stripAccents("Jose\u0301"))
+	assert.Equal(t, `O'Brien said "hi"`, straightenQuotes("O’Brien said “hi”"))
+	assert.Equal(t, "Jane Mary Smith", collapseWhitespace(" Jane\u00a0 Mary\n\tSmith  "))
+	assert.Equal(t, "JaneSmith ", removeNonPrintable("\ufeffJane\u200bSmith\u0007 "))
+	assert.Equal(t, "Ada Lovelace", changeCase("aDA lOVELACE", "title"))
+	assert.Equal(t, "STRASSE", changeCase("straße", "upper"))
+	assert.Equal(t, "zoë", changeCase("ZOË", "lower"))
+	assert.Equal(t, "Zoë", truncate("Zoë Bjørk", 3))
+	assert.Equal(t, "Zoë", truncate("Zoë", 10))
+}
+
+func TestNormalizeUnicode(t *testing.T) {
+	input := "NAME,CODE\nJos\u00e9,\uff21\uff22\uff23\n"
+
+	for _, tc := range []struct {
+		form     string
+		expected string
+	}{
+		{"NFC", input},
+		{"nfkc", "NAME,CODE\nJos\u00e9,ABC\n"},
+		{"NFD", "NAME,CODE\nJose\u0301,\uff21\uff22\uff23\n"},
+		{"NFKD", "NAME,CODE\nJose\u0301,ABC\n"},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		flags := map[string]flagval{
+			"normalizeUnicode": flagval{active: true, value: tc.form},
+		}
+		assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{}, flags))
+		writer.Flush()
+
+		assert.Equal(t, tc.expected, result.String(), tc.form)
+	}
+}
+
+func TestTextRecipe(t *testing.T) {
+	recipe := `steps:
+  - op: remove-non-printable
+  - op: collapse-whitespace
+  - op: strip-accents
+    columns: [SEARCH_NAME]
+  - op: case
+    columns: [SEARCH_NAME]
+    value: upper
+  - op: truncate
+    columns: [NOTE]
+    value: "10"`
+
+	operations, err := loadRecipe(strings.NewReader(recipe))
+	assert.Nil(t, err)
+
+	input := "NAME,SEARCH_NAME,NOTE\n" +
+		"\u200bRenée\u00a0 Dupré,Renée\u00a0 Dupré,Started   on\u00a0the 3rd of March\n"
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, runOperations(strings.NewReader(input), *writer, operations, true, "", ""))
+	writer.Flush()
+
+	assert.Equal(t, `NAME,SEARCH_NAME,NOTE
+Renée Dupré,RENEE DUPRE,Started on
+`, result.String())
+}
+
+func TestTextErrors(t *testing.T) {
+	for _, flags := range []map[string]flagval{
+		{"normalizeUnicode": flagval{active: true, value: "NFX"}},
+		{"changeCase": flagval{active: true, value: "sentence"}},
+		{"truncate": flagval{active: true, value: "-1"}},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.NotNil(t, gumption(strings.NewReader("one,two\n1,2"), *writer, []string{}, flags))
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paidright/datalab/util"
+)
+
+const defaultTzLayout = "YYYY-MM-DD hh:mm:ss"
+
+// tzConversion moves wall clock times from one timezone to another
+type tzConversion struct {
+	from *time.Location
+	// fromColumn is set instead of from when each row carries its own zone
+	fromColumn string
+	to         *time.Location
+	epoch      bool
+
+	inputLayout  string
+	outputLayout string
+
+	// overlap is one of earlier, later or reject and says what to do with
+	// times that happen twice when the clocks go back
+	overlap string
+	// gap is one of shift or reject and says what to do with times that
+	// never happen because the clocks go forward
+	gap string
+
+	zones map[string]*time.Location
+}
+
+func newTzConversion(op *operation, headers []string) (*tzConversion, error) {
+	c := tzConversion{
+		to:      time.UTC,
+		overlap: op.option("dst-overlap", "earlier"),
+		gap:     op.option("dst-gap", "shift"),
+		zones:   map[string]*time.Location{},
+	}
+
+	from := op.flag.value
+	switch op.name {
+	case "convertTz":
+		parts := strings.Split(op.flag.value, ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected a source and a target timezone eg: Australia/Perth,Australia/Sydney")
+		}
+		from = parts[0]
+		to, err := time.LoadLocation(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		c.to = to
+	case "toEpoch":
+		c.epoch = true
+	}
+
+	if strings.HasPrefix(from, "@") {
+		c.fromColumn = strings.TrimPrefix(from, "@")
+		if !util.Contains(c.fromColumn, headers) {
+			return nil, fmt.Errorf("unknown timezone column %s", c.fromColumn)
+		}
+	} else {
+		loc, err := time.LoadLocation(from)
+		if err != nil {
+			return nil, err
+		}
+		c.from = loc
+	}
+
+	layouts := strings.Split(util.DateLayout(op.option("layout", defaultTzLayout)), ",")
+	switch len(layouts) {
+	case 1:
+		c.inputLayout, c.outputLayout = layouts[0], layouts[0]
+	case 2:
+		c.inputLayout, c.outputLayout = layouts[0], layouts[1]
+	default:
+		return nil, fmt.Errorf("expected a layout or an input and an output layout eg: DD/MM/YYYY hh:mm,YYYY-MM-DD hh:mm")
+	}
+
+	if !util.Contains(c.overlap, []string{"earlier", "later", "reject"}) {
+		return nil, fmt.Errorf("dst-overlap must be one of earlier, later or reject")
+	}
+	if !util.Contains(c.gap, []string{"shift", "reject"}) {
+		return nil, fmt.Errorf("dst-gap must be one of shift or reject")
+	}
+
+	return &c, nil
+}
+
+// source finds the timezone a row's times are written in
+func (c *tzConversion) source(row map[string]string) (*time.Location, error) {
+	if c.from != nil {
+		return c.from, nil
+	}
+	name := strings.TrimSpace(row[c.fromColumn])
+	if loc, ok := c.zones[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	c.zones[name] = loc
+	return loc, nil
+}
+
+// convert returns the converted cell and what happened to it. A non empty
+// reject reason means the row should be rejected.
+func (c *tzConversion) convert(cell string, row map[string]string) (string, string, string) {
+	if strings.TrimSpace(cell) == "" {
+		return cell, "blank", ""
+	}
+
+	loc, err := c.source(row)
+	if err != nil {
+		return cell, "unknown timezone", ""
+	}
+
+	wall, err := time.Parse(c.inputLayout, cell)
+	if err != nil {
+		return cell, "unparseable", ""
+	}
+
+	t, outcome := localise(wall, loc, c.overlap)
+	switch {
+	case outcome == "dst gap" && c.gap == "reject",
+		outcome == "dst overlap" && c.overlap == "reject":
+		return cell, "rejected " + outcome, outcome
+	case outcome == "dst gap":
+		outcome = "dst gap shifted"
+	case outcome == "dst overlap":
+		outcome = "dst overlap " + c.overlap
+	}
+
+	if c.epoch {
+		return strconv.FormatInt(t.Unix(), 10), outcome, ""
+	}
+	return t.In(c.to).Format(c.outputLayout), outcome, ""
+}
+
+// localise finds the moment a wall clock in loc refers to. It reports a dst
+// gap for times skipped when the clocks go forward, which are shifted forward
+// by the length of the gap, and a dst overlap for times that happen twice
+// when the clocks go back, which are resolved according to overlap.
+func localise(wall time.Time, loc *time.Location, overlap string) (time.Time, string) {
+	seconds := wall.Unix()
+
+	// Transitions are months apart so the offsets a day either side cover
+	// every reading of the wall clock
+	_, before := time.Unix(seconds-24*60*60, 0).In(loc).Zone()
+	_, after := time.Unix(seconds+24*60*60, 0).In(loc).Zone()
+
+	offsets := []int{before}
+	if after != before {
+		offsets = append(offsets, after)
+	}
+
+	candidates := []time.Time{}
+	for _, offset := range offsets {
+		t := time.Unix(seconds-int64(offset), int64(wall.Nanosecond())).In(loc)
+		local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		if local.Equal(wall) {
+			candidates = append(candidates, t)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Before(candidates[j])
+	})
+
+	switch len(candidates) {
+	case 0:
+		return time.Unix(seconds-int64(before), int64(wall.Nanosecond())).In(loc), "dst gap"
+	case 1:
+		return candidates[0], "converted"
+	}
+
+	if overlap == "later" {
+		return candidates[len(candidates)-1], "dst overlap"
+	}
+	return candidates[0], "dst overlap"
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalise(t *testing.T) {
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	assert.Nil(t, err)
+
+	type localiseTest struct {
+		wall    string
+		overlap string
+		want    string
+		outcome string
+	}
+
+	tests := []localiseTest{
+		{"2020-01-15 09:00:00", "earlier", "2020-01-14T22:00:00Z", "converted"},
+		{"2020-07-15 09:00:00", "earlier", "2020-07-14T23:00:00Z", "converted"},
+		// Clocks go back from 03:00 to 02:00 on 5 April 2020
+		{"2020-04-05 02:30:00", "earlier", "2020-04-04T15:30:00Z", "dst overlap"},
+		{"2020-04-05 02:30:00", "later", "2020-04-04T16:30:00Z", "dst overlap"},
+		// Clocks go forward from 02:00 to 03:00 on 4 October 2020
+		{"2020-10-04 02:30:00", "earlier", "2020-10-03T16:30:00Z", "dst gap"},
+		{"2020-10-04 03:30:00", "earlier", "2020-10-03T16:30:00Z", "converted"},
+	}
+
+	for _, tc := range tests {
+		wall, err := time.Parse("2006-01-02 15:04:05", tc.wall)
+		assert.Nil(t, err)
+
+		got, outcome := localise(wall, sydney, tc.overlap)
+		assert.Equal(t, tc.want, got.UTC().Format(time.RFC3339), tc.wall)
+		assert.Equal(t, tc.outcome, outcome, tc.wall)
+	}
+}
+
+func TestConvertTz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gumption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rejectsPath := path.Join(dir, "rejects.csv")
+
+	flags := map[string]flagval{
+		"convertTz": flagval{
+			active: true,
+			value:  "@TZ,Australia/Brisbane",
+			options: map[string]string{
+				"layout":  "DD/MM/YYYY hh:mm,YYYY-MM-DD hh:mm",
+				"dst-gap": "reject",
+			},
+		},
+		"rejects": flagval{
+			active: true,
+			value:  rejectsPath,
+		},
+	}
+
+	input := `TZ,START
+Australia/Perth,15/01/2020 09:00
+Australia/Sydney,15/01/2020 09:00
+Australia/Sydney,04/10/2020 02:30
+Australia/Sydney,garbage
+Mars/Olympus_Mons,15/01/2020 09:00`
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"START"}, flags))
+	writer.Flush()
+
+	assert.Equal(t, `TZ,START
+Australia/Perth,2020-01-15 11:00
+Australia/Sydney,2020-01-15 08:00
+Australia/Sydney,garbage
+Mars/Olympus_Mons,15/01/2020 09:00
+`, result.String())
+
+	rejected, err := ioutil.ReadFile(rejectsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, `TZ,START,gumption_reject_reason
+Australia/Sydney,04/10/2020 02:30,dst gap
+`, string(rejected))
+}
+
+func TestToEpoch(t *testing.T) {
+	input := "EPOCH,UTC\n2020-01-15 09:00:00,2020-01-15 09:00:00"
+
+	result := strings.Builder{}
+	writer := csv.NewWriter(&result)
+
+	flags := map[string]flagval{
+		"toEpoch": flagval{
+			active: true,
+			value:  "Australia/Adelaide",
+		},
+	}
+
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"EPOCH"}, flags))
+	writer.Flush()
+	assert.Equal(t, "EPOCH,UTC\n1579041000,2020-01-15 09:00:00\n", result.String())
+
+	result = strings.Builder{}
+	writer = csv.NewWriter(&result)
+
+	flags = map[string]flagval{
+		"toUtc": flagval{
+			active: true,
+			value:  "Australia/Adelaide",
+		},
+	}
+
+	assert.Nil(t, gumption(strings.NewReader(input), *writer, []string{"UTC"}, flags))
+	writer.Flush()
+	assert.Equal(t, "EPOCH,UTC\n2020-01-15 09:00:00,2020-01-14 22:30:00\n", result.String())
+}
+
+func TestConvertTzErrors(t *testing.T) {
+	for _, f := range []flagval{
+		{active: true, value: "Australia/Sydney"},
+		{active: true, value: "Nowhere/Special,UTC"},
+		{active: true, value: "@MISSING,UTC"},
+		{active: true, value: "UTC,UTC", options: map[string]string{"dst-gap": "sideways"}},
+	} {
+		result := strings.Builder{}
+		writer := csv.NewWriter(&result)
+
+		assert.NotNil(t, gumption(strings.NewReader("one\n1"), *writer, []string{}, map[string]flagval{"convertTz": f}))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// unit is a measure and how many of its dimension's base unit it is worth.
+// Sizes are exact fractions so conversions don't pick up rounding errors.
+type unit struct {
+	dimension string
+	size      *big.Rat
+}
+
+var units = map[string]unit{
+	"seconds": {"time", big.NewRat(1, 1)},
+	"minutes": {"time", big.NewRat(60, 1)},
+	"hours":   {"time", big.NewRat(60*60, 1)},
+	"days":    {"time", big.NewRat(24*60*60, 1)},
+	"weeks":   {"time", big.NewRat(7*24*60*60, 1)},
+	"mm":      {"length", big.NewRat(1, 1000)},
+	"cm":      {"length", big.NewRat(1, 100)},
+	"m":       {"length", big.NewRat(1, 1)},
+	"km":      {"length", big.NewRat(1000, 1)},
+	"g":       {"mass", big.NewRat(1, 1000)},
+	"kg":      {"mass", big.NewRat(1, 1)},
+	"t":       {"mass", big.NewRat(1000, 1)},
+	"cents":   {"money", big.NewRat(1, 100)},
+	"dollars": {"money", big.NewRat(1, 1)},
+}
+
+func unitNames() string {
+	names := []string{}
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// convertUnits changes a number from one unit to another of the same kind
+func convertUnits(n *big.Rat, from string, to string) (*big.Rat, error) {
+	f, ok := units[from]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %s, expected one of %s", from, unitNames())
+	}
+	t, ok := units[to]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %s, expected one of %s", to, unitNames())
+	}
+	if f.dimension != t.dimension {
+		return nil, fmt.Errorf("cannot convert %s to %s", from, to)
+	}
+	converted := new(big.Rat).Mul(n, f.size)
+	return converted.Quo(converted, t.size), nil
+}
@@ -7,7 +7,6 @@ import (
 	"os"
 	"path"
 	"runtime"
-	"strconv"
 	"sync"
 
 	"github.com/paidright/datalab/util"
@@ -16,6 +15,10 @@ import (
 var version = flag.Bool("version", false, "Just print the version and exit")
 var input = flag.String("input", ".", "The directory to read source CSVs from")
 var quiet = flag.Bool("quiet", false, "Tone down the output noise")
+var indexEvery = flag.Int("index-every", 0, "Write a sidecar .idx file for each input recording the byte offset of every N rows")
+var fromRow = flag.Int("from-row", 0, "Only emit rows from this row number onwards. Uses the sidecar .idx file to seek if present")
+var toRow = flag.Int("to-row", 0, "Only emit rows up to and including this row number")
+var numbering = flag.String("numbering", util.NumberingLogical, "What original_row_number records: logical (CSV record number), physical (line in the file) or offset (byte offset)")
 
 func main() {
 	flag.Parse()
@@ -24,6 +27,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	if !util.Contains(*numbering, util.Numberings) {
+		log.Fatalf("ERROR unknown numbering %s. Expected one of %v", *numbering, util.Numberings)
+	}
+
 	files, err := util.ListFiles(*input, []string{}, []string{".csv"})
 	if err != nil {
 		log.Fatal(err)
@@ -42,6 +49,15 @@ func main() {
 		log.Println(file)
 	}
 
+	if *indexEvery > 0 {
+		for _, file := range files {
+			log.Println("INFO indexing file:", file)
+			if err := util.WriteIndex(file, *indexEvery); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
 	headers, err := enumerateHeaders(files)
 	if err != nil {
 		log.Fatal(err)
@@ -67,7 +83,7 @@ func main() {
 func processFile(file string, headers []string, output *csv.Writer) error {
 	log.Println("INFO working on file:", file)
 
-	work, errors := util.ReadFileAsync(file)
+	work, errors := util.ReadFileRangeAsync(file, *fromRow, *toRow)
 
 	mutex := sync.Mutex{}
 
@@ -93,7 +109,7 @@ func processFile(file string, headers []string, output *csv.Writer) error {
 						line.Data[col] = file
 					}
 					if col == "original_row_number" {
-						line.Data[col] = strconv.Itoa(line.Number)
+						line.Data[col] = line.Position(*numbering)
 					}
 					record = append(record, line.Data[col])
 				}
@@ -6,7 +6,6 @@ import (
 	"io"
 	"log"
 	"os"
-	"strconv"
 
 	"github.com/paidright/datalab/util"
 )
@@ -15,6 +14,7 @@ var version = flag.Bool("version", false, "Just print the version and exit")
 var quiet = flag.Bool("quiet", false, "Tone down the output noise")
 var left = flag.String("left", "left.csv", "The file containing the left hand side of the join")
 var joinkey = flag.String("join-key", "id", "The column on which to do the join")
+var numbering = flag.String("numbering", util.NumberingLogical, "What the original line number columns record: logical (CSV record number), physical (line in the file) or offset (byte offset)")
 
 func main() {
 	flag.Parse()
@@ -23,6 +23,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	if !util.Contains(*numbering, util.Numberings) {
+		log.Fatalf("ERROR unknown numbering %s. Expected one of %v", *numbering, util.Numberings)
+	}
+
 	log.Printf("INFO Stanley is inner joining %s with stdin on %s\n", *left, *joinkey)
 
 	output := csv.NewWriter(os.Stdout)
@@ -66,10 +70,10 @@ func join(key string, left io.Reader, right io.Reader, dest *csv.Writer) error {
 
 	leftCache := map[string]map[string]string{}
 
-	err := util.ReadSource(left, func(line map[string]string, cols []string, lineNumber int) error {
-		leftHeaders = cols
-		line["left_original_line_number"] = strconv.Itoa(lineNumber)
-		leftCache[line[key]] = line
+	err := util.ReadSourceLines(left, func(line util.Line) error {
+		leftHeaders = line.Headers
+		line.Data["left_original_line_number"] = line.Position(*numbering)
+		leftCache[line.Data[key]] = line.Data
 		return nil
 	})
 
@@ -96,7 +100,7 @@ func join(key string, left io.Reader, right io.Reader, dest *csv.Writer) error {
 		for k, v := range leftCache[line.Data[key]] {
 			line.Data[k] = v
 		}
-		line.Data["right_original_line_number"] = strconv.Itoa(line.Number)
+		line.Data["right_original_line_number"] = line.Position(*numbering)
 
 		output := []string{}
 		for _, header := range headers {
@@ -73,3 +73,32 @@ func TestMissingRight(t *testing.T) {
 		assert.Contains(t, result.String(), line)
 	}
 }
+
+func TestPhysicalNumbering(t *testing.T) {
+	*numbering = "physical"
+	defer func() { *numbering = "logical" }()
+
+	left := strings.NewReader(`id,foo
+1,"multi
+line"
+2,x`)
+	right := strings.NewReader(`id,bar
+2,y
+1,b`)
+
+	result := strings.Builder{}
+	output := csv.NewWriter(&result)
+
+	assert.Nil(t, join("id", left, right, output))
+
+	output.Flush()
+
+	expected := []string{
+		"2,x,y,4,2",
+		"1,\"multi\nline\",b,2,3",
+	}
+
+	for _, line := range expected {
+		assert.Contains(t, result.String(), line)
+	}
+}
@@ -0,0 +1,109 @@
+package util
+
+import (
+	"strings"
+	"time"
+)
+
+// ISOFormat can be used in place of a layout to read any ISO 8601 date or
+// timestamp
+const ISOFormat = "ISO8601"
+
+var isoLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+// DateLayout turns our date tokens, or strftime directives if the format
+// contains a %, into a layout that the time package understands
+func DateLayout(format string) string {
+	if strings.Contains(format, "%") {
+		return strftimeLayout(format)
+	}
+
+	format = strings.ReplaceAll(format, "YYYY", "2006")
+	format = strings.ReplaceAll(format, "YY", "06")
+	format = strings.ReplaceAll(format, "MM", "01")
+	format = strings.ReplaceAll(format, "SHORTMONTH", "Jan")
+	format = strings.ReplaceAll(format, "DD", "02")
+
+	// hours
+	format = strings.ReplaceAll(format, "hh", "15")
+	// minutes
+	format = strings.ReplaceAll(format, "mm", "04")
+	// seconds
+	format = strings.ReplaceAll(format, "ss", "05")
+
+	return format
+}
+
+var strftimeDirectives = map[string]string{
+	"Y":  "2006",
+	"y":  "06",
+	"m":  "01",
+	"-m": "1",
+	"b":  "Jan",
+	"h":  "Jan",
+	"B":  "January",
+	"d":  "02",
+	"-d": "2",
+	"e":  "_2",
+	"a":  "Mon",
+	"A":  "Monday",
+	"H":  "15",
+	"I":  "03",
+	"-I": "3",
+	"p":  "PM",
+	"M":  "04",
+	"S":  "05",
+	"z":  "-0700",
+	"Z":  "MST",
+	"F":  "2006-01-02",
+	"T":  "15:04:05",
+	"%":  "%",
+}
+
+// strftimeLayout translates strftime directives such as %d/%m/%Y. Unknown
+// directives are left as they are.
+func strftimeLayout(format string) string {
+	layout := strings.Builder{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			layout.WriteByte(format[i])
+			continue
+		}
+		directive := format[i+1 : i+2]
+		if directive == "-" && i+2 < len(format) {
+			directive = format[i+1 : i+3]
+		}
+		if replacement, ok := strftimeDirectives[directive]; ok {
+			layout.WriteString(replacement)
+			i += len(directive)
+			continue
+		}
+		layout.WriteByte(format[i])
+	}
+	return layout.String()
+}
+
+// ParseISO reads an ISO 8601 date or timestamp
+func ParseISO(cell string) (time.Time, bool) {
+	for _, layout := range isoLayouts {
+		if t, err := time.Parse(layout, cell); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseDate reads a cell written in one of our date formats, or any ISO 8601
+// date if format is ISOFormat
+func ParseDate(format string, cell string) (time.Time, bool) {
+	if format == ISOFormat {
+		return ParseISO(cell)
+	}
+	t, err := time.Parse(DateLayout(format), cell)
+	return t, err == nil
+}
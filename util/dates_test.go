@@ -0,0 +1,38 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDateLayout(t *testing.T) {
+	layouts := map[string]string{
+		"DD.MM.YYYY":          "02.01.2006",
+		"YYYYMMDDhhmmss":      "20060102150405",
+		"%d/%m/%Y":            "02/01/2006",
+		"%-d-%b-%y":           "2-Jan-06",
+		"%F %T":               "2006-01-02 15:04:05",
+		"%I:%M %p":            "03:04 PM",
+		"100%% on %A %e %B":   "100% on Monday _2 January",
+		"%Q is not a thing %": "%Q is not a thing %",
+	}
+
+	for format, want := range layouts {
+		assert.Equal(t, want, DateLayout(format), format)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	for _, cell := range []string{"2020-01-02", "2020-01-02 09:30:00", "2020-01-02T09:30:00+10:00"} {
+		_, ok := ParseDate(ISOFormat, cell)
+		assert.True(t, ok, cell)
+	}
+
+	parsed, ok := ParseDate("DD/MM/YYYY hh:mm", "02/01/2020 09:30")
+	assert.True(t, ok)
+	assert.Equal(t, "2020-01-02T09:30:00Z", parsed.Format("2006-01-02T15:04:05Z07:00"))
+
+	_, ok = ParseDate("DD/MM/YYYY", "2020-01-02")
+	assert.False(t, ok)
+}
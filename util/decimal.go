@@ -0,0 +1,156 @@
+package util
+
+import (
+	"math/big"
+	"strings"
+)
+
+// maxDecimalPlaces caps how many digits are printed for numbers such as 1/3
+// that have no exact decimal representation
+const maxDecimalPlaces = 10
+
+// ParseDecimal reads a plain decimal number such as -12.50 exactly
+func ParseDecimal(input string) (*big.Rat, bool) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, false
+	}
+	for _, r := range input {
+		if !(r >= '0' && r <= '9') && r != '.' && r != '-' && r != '+' {
+			return nil, false
+		}
+	}
+
+	r, ok := new(big.Rat).SetString(input)
+	return r, ok
+}
+
+// FormatDecimal prints a number in plain decimal notation without trailing
+// zeroes. Numbers that don't terminate are cut off at maxDecimalPlaces.
+func FormatDecimal(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+
+	places := maxDecimalPlaces
+	if exact, ok := decimalPlaces(r); ok && exact < places {
+		places = exact
+	}
+
+	s := r.FloatString(places)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+// decimalPlaces reports how many places are needed to print r exactly, if it
+// can be printed exactly at all
+func decimalPlaces(r *big.Rat) (int, bool) {
+	denom := new(big.Int).Set(r.Denom())
+	two := big.NewInt(2)
+	five := big.NewInt(5)
+	zero := big.NewInt(0)
+	mod := new(big.Int)
+
+	twos, fives := 0, 0
+	for mod.Mod(denom, two).Cmp(zero) == 0 {
+		denom.Div(denom, two)
+		twos++
+	}
+	for mod.Mod(denom, five).Cmp(zero) == 0 {
+		denom.Div(denom, five)
+		fives++
+	}
+
+	if denom.Cmp(big.NewInt(1)) != 0 {
+		return 0, false
+	}
+	if twos > fives {
+		return twos, true
+	}
+	return fives, true
+}
+
+// RoundingMode says which way to go when a number falls between two values
+// that can be represented with the chosen number of decimal places
+type RoundingMode string
+
+const (
+	// RoundHalfUp rounds halves away from zero
+	RoundHalfUp RoundingMode = "half-up"
+	// RoundHalfDown rounds halves towards zero
+	RoundHalfDown RoundingMode = "half-down"
+	// RoundHalfEven rounds halves to the nearest even digit, also known as
+	// banker's rounding
+	RoundHalfEven RoundingMode = "half-even"
+	// RoundUp always rounds away from zero
+	RoundUp RoundingMode = "up"
+	// RoundDown always rounds towards zero, ie: truncates
+	RoundDown RoundingMode = "down"
+	// RoundCeiling always rounds towards positive infinity
+	RoundCeiling RoundingMode = "ceiling"
+	// RoundFloor always rounds towards negative infinity
+	RoundFloor RoundingMode = "floor"
+)
+
+var RoundingModes = []string{
+	string(RoundHalfUp),
+	string(RoundHalfDown),
+	string(RoundHalfEven),
+	string(RoundUp),
+	string(RoundDown),
+	string(RoundCeiling),
+	string(RoundFloor),
+}
+
+// RoundDecimal rounds to the given number of decimal places with halves going
+// away from zero
+func RoundDecimal(r *big.Rat, places int) *big.Rat {
+	return RoundDecimalMode(r, places, RoundHalfUp)
+}
+
+// RoundDecimalMode rounds to the given number of decimal places using mode
+func RoundDecimalMode(r *big.Rat, places int, mode RoundingMode) *big.Rat {
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil))
+	scaled := new(big.Rat).Mul(r, scale)
+
+	q, m := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if m.Sign() == 0 {
+		return new(big.Rat).Quo(new(big.Rat).SetInt(q), scale)
+	}
+
+	// half compares the remainder with one half: -1 below, 0 exactly, 1 above
+	twice := new(big.Int).Mul(new(big.Int).Abs(m), big.NewInt(2))
+	half := twice.Cmp(scaled.Denom())
+
+	away := false
+	switch mode {
+	case RoundHalfUp:
+		away = half >= 0
+	case RoundHalfDown:
+		away = half > 0
+	case RoundHalfEven:
+		away = half > 0 || (half == 0 && q.Bit(0) == 1)
+	case RoundUp:
+		away = true
+	case RoundDown:
+		away = false
+	case RoundCeiling:
+		away = scaled.Sign() > 0
+	case RoundFloor:
+		away = scaled.Sign() < 0
+	}
+
+	if away {
+		if scaled.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+
+	return new(big.Rat).Quo(new(big.Rat).SetInt(q), scale)
+}
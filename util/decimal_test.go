@@ -0,0 +1,54 @@
+package util
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatDecimal(t *testing.T) {
+	tests := map[string]*big.Rat{
+		"0.3":          new(big.Rat).Add(big.NewRat(1, 10), big.NewRat(2, 10)),
+		"12":           big.NewRat(12, 1),
+		"-12.5":        big.NewRat(-25, 2),
+		"0.3333333333": big.NewRat(1, 3),
+	}
+
+	for want, r := range tests {
+		assert.Equal(t, want, FormatDecimal(r))
+	}
+}
+
+func TestRoundDecimalMode(t *testing.T) {
+	type roundTest struct {
+		input string
+		mode  RoundingMode
+		want  string
+	}
+
+	tests := []roundTest{
+		{"2.345", RoundHalfUp, "2.35"},
+		{"-2.345", RoundHalfUp, "-2.35"},
+		{"2.345", RoundHalfDown, "2.34"},
+		{"2.3451", RoundHalfDown, "2.35"},
+		{"2.345", RoundHalfEven, "2.34"},
+		{"2.355", RoundHalfEven, "2.36"},
+		{"-2.345", RoundHalfEven, "-2.34"},
+		{"2.341", RoundUp, "2.35"},
+		{"-2.341", RoundUp, "-2.35"},
+		{"2.349", RoundDown, "2.34"},
+		{"-2.349", RoundDown, "-2.34"},
+		{"-2.341", RoundCeiling, "-2.34"},
+		{"2.341", RoundCeiling, "2.35"},
+		{"-2.341", RoundFloor, "-2.35"},
+		{"2.349", RoundFloor, "2.34"},
+		{"2.3", RoundUp, "2.3"},
+	}
+
+	for _, tc := range tests {
+		r, ok := ParseDecimal(tc.input)
+		assert.True(t, ok)
+		assert.Equal(t, tc.want, FormatDecimal(RoundDecimalMode(r, 2, tc.mode)), tc.input, tc.mode)
+	}
+}
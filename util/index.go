@@ -0,0 +1,189 @@
+package util
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// IndexEntry records the physical line and byte offset at which a logical row
+// starts. Rows are numbered the same way as Line.Number, so the header is row 1.
+type IndexEntry struct {
+	Row    int
+	Line   int
+	Offset int64
+}
+
+// IndexPath is the location of the sidecar index for a CSV file
+func IndexPath(path string) string {
+	return path + ".idx"
+}
+
+// BuildIndex records the position of every nth logical row in the input.
+// Quoted fields may contain newlines, so rows are found with the same scanner
+// the readers use rather than by counting lines.
+func BuildIndex(input io.Reader, every int) ([]IndexEntry, error) {
+	if every < 1 {
+		return []IndexEntry{}, fmt.Errorf("index interval must be at least 1, got %d", every)
+	}
+
+	s := newRecordScanner(input)
+	entries := []IndexEntry{}
+
+	for row := 1; ; row++ {
+		_, line, offset, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, err
+		}
+
+		if (row-1)%every == 0 {
+			entries = append(entries, IndexEntry{Row: row, Line: line, Offset: offset})
+		}
+	}
+
+	return entries, nil
+}
+
+// WriteIndex builds an index for the file at path and saves it alongside it
+func WriteIndex(path string, every int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := BuildIndex(f, every)
+	if err != nil {
+		return fmt.Errorf("error indexing file %s: %w", path, err)
+	}
+
+	out, err := os.Create(IndexPath(path))
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"row", "line", "offset"}); err != nil {
+		out.Close()
+		return err
+	}
+	for _, entry := range entries {
+		record := []string{strconv.Itoa(entry.Row), strconv.Itoa(entry.Line), strconv.FormatInt(entry.Offset, 10)}
+		if err := w.Write(record); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// ReadIndex loads a sidecar index written by WriteIndex
+func ReadIndex(path string) ([]IndexEntry, error) {
+	entries := []IndexEntry{}
+
+	err := ReadFile(path, func(line map[string]string, headers []string, lineNumber int) error {
+		row, err := strconv.Atoi(line["row"])
+		if err != nil {
+			return fmt.Errorf("bad row at line %d: %w", lineNumber, err)
+		}
+		physical, err := strconv.Atoi(line["line"])
+		if err != nil {
+			return fmt.Errorf("bad line at line %d: %w", lineNumber, err)
+		}
+		offset, err := strconv.ParseInt(line["offset"], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad offset at line %d: %w", lineNumber, err)
+		}
+		entries = append(entries, IndexEntry{Row: row, Line: physical, Offset: offset})
+		return nil
+	})
+
+	return entries, err
+}
+
+// ReadFileRangeAsync streams the rows numbered from through to inclusive. A
+// zero from or to leaves that end of the range open. When a sidecar index
+// exists the file is seeked to the nearest indexed row instead of scanned.
+func ReadFileRangeAsync(path string, from int, to int) (chan Line, chan error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return failedRead(err)
+	}
+
+	cols, _, _, err := newRecordScanner(f).next()
+	if err != nil {
+		f.Close()
+		return failedRead(fmt.Errorf("error reading headers from %s: %w", path, err))
+	}
+
+	start := IndexEntry{Row: 1, Line: 1, Offset: 0}
+	if from > 1 {
+		entries, err := loadFreshIndex(path)
+		if err != nil {
+			log.Println("WARN ignoring index for", path, err)
+		}
+		for _, entry := range entries {
+			if entry.Row > 1 && entry.Row <= from && entry.Row > start.Row {
+				start = entry
+			}
+		}
+	}
+
+	if _, err := f.Seek(start.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return failedRead(err)
+	}
+
+	s := newRecordScanner(f)
+	s.resume(start.Line, start.Offset)
+
+	if start.Row == 1 {
+		return readRangeAsync(s, nil, 0, from, to, f)
+	}
+
+	s.fields = len(cols)
+
+	return readRangeAsync(s, cols, start.Row-1, from, to, f)
+}
+
+func loadFreshIndex(path string) ([]IndexEntry, error) {
+	idx, err := os.Stat(IndexPath(path))
+	if os.IsNotExist(err) {
+		return []IndexEntry{}, nil
+	}
+	if err != nil {
+		return []IndexEntry{}, err
+	}
+
+	src, err := os.Stat(path)
+	if err != nil {
+		return []IndexEntry{}, err
+	}
+
+	if src.ModTime().After(idx.ModTime()) {
+		return []IndexEntry{}, fmt.Errorf("index is older than the file it indexes")
+	}
+
+	return ReadIndex(IndexPath(path))
+}
+
+func failedRead(err error) (chan Line, chan error) {
+	work := make(chan Line)
+	errors := make(chan error, 1)
+	errors <- err
+	close(work)
+	close(errors)
+	return work, errors
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const indexInput = "id,note\n1,plain\n2,\"multi\nline\"\n\n3,\"quoted \"\"comma,\"\"\"\n4,last\n"
+
+func TestBuildIndex(t *testing.T) {
+	entries, err := BuildIndex(strings.NewReader(indexInput), 2)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []IndexEntry{
+		{Row: 1, Line: 1, Offset: 0},
+		{Row: 3, Line: 3, Offset: 16},
+		{Row: 5, Line: 7, Offset: 54},
+	}, entries)
+
+	for _, entry := range entries {
+		assert.NotEqual(t, byte('\n'), indexInput[entry.Offset])
+	}
+}
+
+func TestBuildIndexBadInterval(t *testing.T) {
+	_, err := BuildIndex(strings.NewReader(indexInput), 0)
+	assert.NotNil(t, err)
+}
+
+func TestReadFileRangeAsync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datalab_index")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "input.csv")
+	assert.Nil(t, ioutil.WriteFile(file, []byte(indexInput), 0644))
+
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			assert.Nil(t, WriteIndex(file, 2))
+		}
+
+		work, errors := ReadFileRangeAsync(file, 3, 4)
+
+		lines := []Line{}
+		for line := range work {
+			lines = append(lines, line)
+		}
+		for err := range errors {
+			assert.Nil(t, err)
+		}
+
+		assert.Equal(t, 2, len(lines))
+		assert.Equal(t, 3, lines[0].Number)
+		assert.Equal(t, 3, lines[0].PhysicalLine)
+		assert.Equal(t, int64(16), lines[0].Offset)
+		assert.Equal(t, "multi\nline", lines[0].Data["note"])
+		assert.Equal(t, []string{"id", "note"}, lines[0].Headers)
+		assert.Equal(t, 4, lines[1].Number)
+		assert.Equal(t, 6, lines[1].PhysicalLine)
+		assert.Equal(t, `quoted "comma,"`, lines[1].Data["note"])
+	}
+
+	entries, err := ReadIndex(IndexPath(file))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"strconv"
 )
 
 type lineFunc func(line map[string]string, headers []string, lineNumber int) error
@@ -15,22 +16,56 @@ type lineFunc func(line map[string]string, headers []string, lineNumber int) err
 type Line struct {
 	Data    map[string]string
 	Headers []string
-	Number  int
+	// Number counts logical records, starting with the header as 1
+	Number int
+	// PhysicalLine is the line of the file on which the record starts. It
+	// differs from Number when quoted fields contain newlines.
+	PhysicalLine int
+	// Offset is the byte offset at which the record starts
+	Offset int64
+	// Record holds the fields exactly as they were read
+	Record []string
 }
 
-func ReadSourceAsync(input io.Reader) (chan Line, chan error) {
-	r := csv.NewReader(input)
+const (
+	NumberingLogical  = "logical"
+	NumberingPhysical = "physical"
+	NumberingOffset   = "offset"
+)
 
-	cols := []string{}
-	lineNumber := 0
+// Numberings lists the ways a line can describe where it came from
+var Numberings = []string{NumberingLogical, NumberingPhysical, NumberingOffset}
+
+// Position describes where the line came from using one of the Numberings
+func (l Line) Position(numbering string) string {
+	switch numbering {
+	case NumberingPhysical:
+		return strconv.Itoa(l.PhysicalLine)
+	case NumberingOffset:
+		return strconv.FormatInt(l.Offset, 10)
+	default:
+		return strconv.Itoa(l.Number)
+	}
+}
+
+func ReadSourceAsync(input io.Reader) (chan Line, chan error) {
+	return readRangeAsync(newRecordScanner(input), nil, 0, 0, 0, nil)
+}
 
+// readRangeAsync streams records from s, numbering them on from lineNumber. If
+// cols is nil the first record read is taken as the headers. Records numbered
+// outside of from and to are skipped, with zero leaving that end open.
+func readRangeAsync(s *recordScanner, cols []string, lineNumber int, from int, to int, source io.Closer) (chan Line, chan error) {
 	work := make(chan Line, 1000)
 	errors := make(chan error)
 
 	go (func() {
 		for {
 			lineNumber += 1
-			record, err := r.Read()
+			if to > 0 && lineNumber > to {
+				break
+			}
+			record, physicalLine, offset, err := s.next()
 			if err == io.EOF {
 				break
 			}
@@ -39,15 +74,22 @@ func ReadSourceAsync(input io.Reader) (chan Line, chan error) {
 				break
 			}
 
-			if lineNumber == 1 {
+			if cols == nil {
 				cols = record
 				continue
 			}
 
+			if lineNumber < from {
+				continue
+			}
+
 			line := Line{
-				Number:  lineNumber,
-				Headers: cols,
-				Data:    map[string]string{},
+				Number:       lineNumber,
+				PhysicalLine: physicalLine,
+				Offset:       offset,
+				Headers:      cols,
+				Data:         map[string]string{},
+				Record:       record,
 			}
 
 			for i, col := range cols {
@@ -61,6 +103,12 @@ func ReadSourceAsync(input io.Reader) (chan Line, chan error) {
 			work <- line
 		}
 
+		if source != nil {
+			if err := source.Close(); err != nil {
+				log.Println("ERROR closing file: ", err)
+			}
+		}
+
 		close(work)
 		close(errors)
 	})()
@@ -72,26 +120,29 @@ func ReadFileAsync(path string) (chan Line, chan error) {
 	f, err := os.Open(path)
 
 	if err != nil {
-		work := make(chan Line)
-		errors := make(chan error)
-		errors <- err
-		return work, errors
+		return failedRead(err)
 	}
 
-	work, errors := ReadSourceAsync(f)
-
-	return work, errors
+	return readRangeAsync(newRecordScanner(f), nil, 0, 0, 0, f)
 }
 
 func ReadSource(input io.Reader, handler lineFunc) error {
-	r := csv.NewReader(input)
+	return ReadSourceLines(input, func(line Line) error {
+		return handler(line.Data, line.Headers, line.Number)
+	})
+}
+
+// ReadSourceLines is ReadSource for handlers that want to know where each line
+// came from
+func ReadSourceLines(input io.Reader, handler func(line Line) error) error {
+	s := newRecordScanner(input)
 
 	cols := []string{}
 	lineNumber := 0
 
 	for {
 		lineNumber += 1
-		record, err := r.Read()
+		record, physicalLine, offset, err := s.next()
 		if err == io.EOF {
 			break
 		}
@@ -104,15 +155,22 @@ func ReadSource(input io.Reader, handler lineFunc) error {
 			continue
 		}
 
-		line := map[string]string{}
+		line := Line{
+			Number:       lineNumber,
+			PhysicalLine: physicalLine,
+			Offset:       offset,
+			Headers:      cols,
+			Data:         map[string]string{},
+			Record:       record,
+		}
 		for i, col := range cols {
 			if !(len(record) > i) {
 				return fmt.Errorf("WARN Invalid CSV. Missing column at line %d", i)
 			}
-			line[col] = record[i]
+			line.Data[col] = record[i]
 		}
 
-		if err := handler(line, cols, lineNumber); err != nil {
+		if err := handler(line); err != nil {
 			return err
 		}
 	}
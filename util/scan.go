@@ -0,0 +1,202 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"io"
+)
+
+// recordScanner splits CSV input into records while keeping track of where
+// each record starts. It follows the same rules as encoding/csv with its
+// default settings: blank lines are skipped, quoted fields may span lines and
+// \r\n line endings are treated as \n.
+type recordScanner struct {
+	r      *bufio.Reader
+	line   int
+	offset int64
+	fields int
+	// buf holds lines too long for the reader's buffer
+	buf []byte
+	// record holds the unquoted text of the fields being read
+	record []byte
+	ends   []int
+}
+
+func newRecordScanner(input io.Reader) *recordScanner {
+	return &recordScanner{r: bufio.NewReader(input)}
+}
+
+// resume positions the scanner as if it had already consumed everything before
+// the given physical line and byte offset
+func (s *recordScanner) resume(line int, offset int64) {
+	s.line = line - 1
+	s.offset = offset
+}
+
+// readLine reads the next physical line, keeping count of lines and bytes.
+// Like encoding/csv, \r\n is turned into \n and a \r at the very end of the
+// input is dropped.
+func (s *recordScanner) readLine() ([]byte, error) {
+	line, err := s.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		s.buf = append(s.buf[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = s.r.ReadSlice('\n')
+			s.buf = append(s.buf, line...)
+		}
+		line = s.buf
+	}
+	s.offset += int64(len(line))
+	if len(line) > 0 {
+		s.line++
+	}
+	if len(line) > 0 && err == io.EOF {
+		err = nil
+		if line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+	}
+	if n := len(line); n >= 2 && line[n-2] == '\r' && line[n-1] == '\n' {
+		line[n-2] = '\n'
+		line = line[:n-1]
+	}
+	return line, err
+}
+
+// lengthNL is 1 if line ends in a \n
+func lengthNL(line []byte) int {
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		return 1
+	}
+	return 0
+}
+
+// next returns the next record along with the physical line and byte offset at
+// which it starts
+func (s *recordScanner) next() ([]string, int, int64, error) {
+	var line []byte
+	var errRead error
+	startOffset := s.offset
+	for errRead == nil {
+		startOffset = s.offset
+		line, errRead = s.readLine()
+		if errRead == nil && len(line) == lengthNL(line) {
+			continue
+		}
+		break
+	}
+	if errRead == io.EOF {
+		return nil, 0, 0, errRead
+	}
+
+	startLine := s.line
+	s.record = s.record[:0]
+	ends := s.ends[:0]
+
+	fail := func(err error) error {
+		return &csv.ParseError{
+			StartLine: startLine,
+			Line:      s.line,
+			Column:    len(ends) + 1,
+			Err:       err,
+		}
+	}
+
+	var err error
+parseField:
+	for {
+		if len(line) == 0 || line[0] != '"' {
+			i := bytes.IndexByte(line, ',')
+			field := line
+			if i >= 0 {
+				field = field[:i]
+			} else {
+				field = field[:len(field)-lengthNL(field)]
+			}
+			if bytes.IndexByte(field, '"') >= 0 {
+				err = fail(csv.ErrBareQuote)
+				break parseField
+			}
+			s.record = append(s.record, field...)
+			ends = append(ends, len(s.record))
+			if i >= 0 {
+				line = line[i+1:]
+				continue parseField
+			}
+			break parseField
+		}
+
+		line = line[1:]
+		for {
+			i := bytes.IndexByte(line, '"')
+			switch {
+			case i >= 0:
+				s.record = append(s.record, line[:i]...)
+				line = line[i+1:]
+				switch {
+				case len(line) > 0 && line[0] == '"':
+					s.record = append(s.record, '"')
+					line = line[1:]
+				case len(line) > 0 && line[0] == ',':
+					line = line[1:]
+					ends = append(ends, len(s.record))
+					continue parseField
+				case lengthNL(line) == len(line):
+					ends = append(ends, len(s.record))
+					break parseField
+				default:
+					err = fail(csv.ErrQuote)
+					break parseField
+				}
+			case len(line) > 0:
+				// The field goes on to the next line
+				s.record = append(s.record, line...)
+				if errRead != nil {
+					break parseField
+				}
+				line, errRead = s.readLine()
+				if errRead == io.EOF {
+					errRead = nil
+				}
+			default:
+				if errRead == nil {
+					err = fail(csv.ErrQuote)
+					break parseField
+				}
+				ends = append(ends, len(s.record))
+				break parseField
+			}
+		}
+	}
+	if err == nil {
+		err = errRead
+	}
+
+	s.ends = ends
+
+	// One string for the whole record, sliced up into fields
+	all := string(s.record)
+	record := make([]string, len(ends))
+	start := 0
+	for i, end := range ends {
+		record[i] = all[start:end]
+		start = end
+	}
+	if err != nil {
+		return record, startLine, startOffset, err
+	}
+
+	if s.fields == 0 {
+		s.fields = len(record)
+	} else if len(record) != s.fields {
+		return record, startLine, startOffset, &csv.ParseError{
+			StartLine: startLine,
+			Line:      startLine,
+			Column:    1,
+			Err:       csv.ErrFieldCount,
+		}
+	}
+
+	return record, startLine, startOffset, nil
+}
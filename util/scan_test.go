@@ -0,0 +1,202 @@
+package util
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSourceLinesPositions(t *testing.T) {
+	input := "id,note\r\n1,plain\r\n2,\"two\r\nlines\"\r\n\r\n3,\"a\nb\nc\"\n4,end"
+
+	lines := []Line{}
+	err := ReadSourceLines(strings.NewReader(input), func(line Line) error {
+		lines = append(lines, line)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 4, len(lines))
+
+	numbers := []int{}
+	physical := []int{}
+	offsets := []int64{}
+	for _, line := range lines {
+		numbers = append(numbers, line.Number)
+		physical = append(physical, line.PhysicalLine)
+		offsets = append(offsets, line.Offset)
+	}
+
+	assert.Equal(t, []int{2, 3, 4, 5}, numbers)
+	assert.Equal(t, []int{2, 3, 6, 9}, physical)
+	assert.Equal(t, []int64{9, 18, 36, 46}, offsets)
+
+	assert.Equal(t, "two\nlines", lines[1].Data["note"])
+	assert.Equal(t, "a\nb\nc", lines[2].Data["note"])
+	assert.Equal(t, "end", lines[3].Data["note"])
+
+	assert.Equal(t, "3", lines[1].Position(NumberingLogical))
+	assert.Equal(t, "3", lines[1].Position(NumberingPhysical))
+	assert.Equal(t, "6", lines[2].Position(NumberingPhysical))
+	assert.Equal(t, "36", lines[2].Position(NumberingOffset))
+}
+
+// readWithCSV reads every record with encoding/csv, stopping at the first error
+func readWithCSV(input string) ([][]string, error) {
+	records := [][]string{}
+	r := csv.NewReader(strings.NewReader(input))
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+// readWithScanner reads every record with a recordScanner, stopping at the
+// first error
+func readWithScanner(input string) ([][]string, error) {
+	records := [][]string{}
+	s := newRecordScanner(strings.NewReader(input))
+	for {
+		record, _, _, err := s.next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+func assertParity(t *testing.T, input string) bool {
+	want, wantErr := readWithCSV(input)
+	got, gotErr := readWithScanner(input)
+
+	ok := assert.Equal(t, want, got, "%q", input)
+	if wantErr == nil {
+		return assert.Nil(t, gotErr, "%q", input) && ok
+	}
+
+	var wantParse, gotParse *csv.ParseError
+	ok = assert.True(t, errors.As(wantErr, &wantParse), "%q", input) && ok
+	ok = assert.True(t, errors.As(gotErr, &gotParse), "%q", input) && ok
+	if !ok {
+		return false
+	}
+	return assert.Equal(t, wantParse.Err, gotParse.Err, "%q", input) &&
+		assert.Equal(t, wantParse.StartLine, gotParse.StartLine, "%q", input)
+}
+
+func TestScannerMatchesEncodingCSV(t *testing.T) {
+	inputs := []string{
+		"a,b\n1,2\n",
+		"a,b\n\"1,\"\"x\"\"\",2",
+		"a,b\n,\n",
+		"a,b\n1,2\r",
+		"a\n\"unterminated\n",
+		"a,b\n1,b\"ad\n",
+		"a,b\n\"1\"x,2\n",
+		"a,b\n1,2,3\n",
+		// quotes
+		"a,b\n\"\",\"\"\n",
+		"a,b\n\"\"\"\",\"x\"\"\"\n",
+		"a,b\n\"quoted, with comma\",\"line\nbreak\"\n",
+		// CRLF, inside and outside quotes
+		"a,b\r\n1,2\r\n",
+		"a,b\r\n\"1\r\n2\",3\r\n",
+		"a,b\r\n1,2\r\r\n",
+		"a,b\n1\r2,3\n",
+		"a,b\n\"1\r2\",3\n",
+		// bare quotes
+		"a,b\n1\",2\n",
+		"a,b\n x\"y\",2\n",
+		"a,b\n \"1\",2\n",
+		// empty trailing fields
+		"a,b,c\n1,,\n",
+		"a,b,c\n,,\n,,",
+		"a,b\n1,\"\"",
+		// blank lines
+		"\n\na,b\n\n\n1,2\n\n",
+		"a,b\r\n\r\n1,2",
+		// field counts
+		"a,b\n1\n",
+		"a,b\n1,2\n3,4,5\n",
+		"a\n1\n2,3\n",
+		"",
+		"\n",
+		// lines longer than the reader's buffer
+		"a,b\n" + strings.Repeat("x", 5000) + ",2\r\n3,4\n",
+		"a,b\n\"" + strings.Repeat("x\r\n", 3000) + "\",2\n",
+	}
+
+	for _, input := range inputs {
+		assertParity(t, input)
+	}
+}
+
+func TestScannerFuzzParity(t *testing.T) {
+	// Inputs made of the characters that matter to CSV, so every combination
+	// of quoting, line endings and field counts turns up
+	alphabet := []string{"a", "b", ",", "\"", "\"\"", "\n", "\r", "\r\n", " "}
+	random := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		input := strings.Builder{}
+		for j := random.Intn(30); j > 0; j-- {
+			input.WriteString(alphabet[random.Intn(len(alphabet))])
+		}
+		if !assertParity(t, input.String()) {
+			return
+		}
+	}
+}
+
+// benchmarkInput is a timesheet-like file with some quoted fields
+func benchmarkInput() string {
+	b := strings.Builder{}
+	b.WriteString("id,name,note,start,end,hours\n")
+	for i := 0; i < 20000; i++ {
+		b.WriteString("12345,\"Smith, Jane\",\"said \"\"hi\"\"\nthen left\",2020-01-01 09:00:00,2020-01-01 17:00:00,7.6\n")
+		b.WriteString("12346,John Citizen,,2020-01-01 09:00:00,2020-01-01 17:00:00,8\r\n")
+	}
+	return b.String()
+}
+
+func BenchmarkEncodingCSV(b *testing.B) {
+	input := benchmarkInput()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := csv.NewReader(strings.NewReader(input))
+		for {
+			if _, err := r.Read(); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkRecordScanner(b *testing.B) {
+	input := benchmarkInput()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := newRecordScanner(strings.NewReader(input))
+		for {
+			if _, _, _, err := s.next(); err != nil {
+				break
+			}
+		}
+	}
+}